@@ -1,28 +1,49 @@
 package zim
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"strings"
-	"sync"
 
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/klauspost/compress/zstd"
 )
 
 const (
 	RedirectEntry   uint16 = 0xffff
-	LinkTargetEntry        = 0xfffe
-	DeletedEntry           = 0xfffd
+	LinkTargetEntry uint16 = 0xfffe
+	DeletedEntry    uint16 = 0xfffd
 )
 
-var articlePool sync.Pool
+// EntryKind is the kind of a directory entry
+type EntryKind uint8
 
-// the recent uncompressed blobs, mainly useful while indexing and asking
-// for the same blob again and again
-var bcache *lru.ARCCache
+const (
+	// ContentKind entries point to a blob in a cluster
+	ContentKind EntryKind = iota
+	// RedirectKind entries point to another entry
+	RedirectKind
+	// LinkTargetKind entries have a url and a title but no data
+	LinkTargetKind
+	// DeletedKind entries have been removed from the file
+	DeletedKind
+)
+
+func (k EntryKind) String() string {
+	switch k {
+	case ContentKind:
+		return "content"
+	case RedirectKind:
+		return "redirect"
+	case LinkTargetKind:
+		return "linktarget"
+	case DeletedKind:
+		return "deleted"
+	}
+	return "unknown"
+}
 
 type Article struct {
 	// EntryType is a RedirectEntry/LinkTargetEntry/DeletedEntry or an idx
@@ -48,15 +69,18 @@ func (z *ZimReader) ArticleAtURLIdx(idx uint32) (*Article, error) {
 
 // return the article main page if it exists
 func (z *ZimReader) MainPage() (*Article, error) {
-	if z.mainPage == 0xffffffff {
+	if !z.header.HasMainPage() {
 		return nil, nil
 	}
-	return z.ArticleAtURLIdx(z.mainPage)
+	if z.header.MainPage >= z.ArticleCount {
+		return nil, corruptf("invalid main page index %d", z.header.MainPage)
+	}
+	return z.ArticleAtURLIdx(z.header.MainPage)
 }
 
 // get the article (Directory) pointed by the offset found in URLpos or Titlepos
 func (z *ZimReader) ArticleAt(offset uint64) (*Article, error) {
-	a := articlePool.Get().(*Article)
+	a := z.articlePool.Get().(*Article)
 	err := z.FillArticleAt(a, offset)
 	return a, err
 }
@@ -65,6 +89,8 @@ func (z *ZimReader) ArticleAt(offset uint64) (*Article, error) {
 func (z *ZimReader) FillArticleAt(a *Article, offset uint64) error {
 	a.z = z
 	a.URLPtr = offset
+	a.cluster = 0
+	a.blob = 0
 
 	mimeIdx, err := readInt16(z.bytesRangeAt(offset, offset+2))
 	if err != nil {
@@ -72,145 +98,115 @@ func (z *ZimReader) FillArticleAt(a *Article, offset uint64) error {
 	}
 	a.EntryType = mimeIdx
 
-	// Linktarget or Target Entry
-	if mimeIdx == LinkTargetEntry || mimeIdx == DeletedEntry {
-		// TODO
-		return nil
-	}
-
 	s, err := z.bytesRangeAt(offset+3, offset+4)
 	if err != nil {
 		return err
 	}
 	a.Namespace = s[0]
 
-	a.cluster, err = readInt32(z.bytesRangeAt(offset+8, offset+8+4))
-	if err != nil {
-		return err
-	}
-	a.blob, err = readInt32(z.bytesRangeAt(offset+12, offset+12+4))
-	if err != nil {
-		return err
-	}
-
-	// Redirect
-	if mimeIdx == RedirectEntry {
-		// assume the url + title won't be longer than 2k
-		b, err := z.bytesRangeAt(offset+12, offset+12+2048)
-		if err != nil {
-			return nil
+	switch a.Kind() {
+	case ContentKind:
+		if int(a.EntryType) >= len(z.mimeTypeList) {
+			return corruptf("invalid mime type %d at 0x%x", a.EntryType, offset)
 		}
-		bbuf := bytes.NewBuffer(b)
-		a.url, err = bbuf.ReadString('\x00')
-		if err != nil {
-			return err
-		}
-		a.url = strings.TrimRight(a.url, "\x00")
+	case LinkTargetKind, DeletedKind:
+		// no cluster nor redirect index, url and title follow the revision
+		return z.fillURLTitle(a, offset+8)
 
-		a.Title, err = bbuf.ReadString('\x00')
+	case RedirectKind:
+		// We use the cluster to save the redirect index position for RedirectEntry type
+		a.cluster, err = readInt32(z.bytesRangeAt(offset+8, offset+8+4))
 		if err != nil {
 			return err
 		}
-		a.Title = strings.TrimRight(a.Title, "\x00")
-		return err
+		return z.fillURLTitle(a, offset+12)
 	}
 
-	b, err := z.bytesRangeAt(offset+16, offset+16+2048)
+	a.cluster, err = readInt32(z.bytesRangeAt(offset+8, offset+8+4))
 	if err != nil {
-		return nil
+		return err
 	}
-	bbuf := bytes.NewBuffer(b)
-	a.url, err = bbuf.ReadString('\x00')
+	a.blob, err = readInt32(z.bytesRangeAt(offset+12, offset+12+4))
 	if err != nil {
 		return err
 	}
 
-	a.url = strings.TrimRight(string(a.url), "\x00")
+	return z.fillURLTitle(a, offset+16)
+}
 
-	title, err := bbuf.ReadString('\x00')
+// read the zero terminated url and title starting at offset
+func (z *ZimReader) fillURLTitle(a *Article, offset uint64) error {
+	url, next, err := z.readCString(offset)
 	if err != nil {
-		return err
+		return fmt.Errorf("can't read article url %w", err)
 	}
-	title = strings.TrimRight(string(title), "\x00")
-	// This is a trick to force a copy and avoid retain of the full buffer
-	// mainly for indexing title reasons
-	if len(title) != 0 {
-		a.Title = title[0:1] + title[1:]
+	title, _, err := z.readCString(next)
+	if err != nil {
+		return fmt.Errorf("can't read article title %w", err)
 	}
+
+	a.url = url
+	a.Title = title
 	return nil
 }
 
+// Kind returns the kind of the directory entry
+func (a *Article) Kind() EntryKind {
+	switch a.EntryType {
+	case RedirectEntry:
+		return RedirectKind
+	case LinkTargetEntry:
+		return LinkTargetKind
+	case DeletedEntry:
+		return DeletedKind
+	}
+	return ContentKind
+}
+
+// return the title used to sort the title index, the url when there is no title
+func (a *Article) sortTitle() string {
+	if a.Title == "" {
+		return a.url
+	}
+	return a.Title
+}
+
 // return the uncompressed data associated with this article
+// on the first miss of a compressed cluster only the start of the cluster up
+// to the blob is decompressed, the whole cluster is decompressed and cached
+// when it's requested again or when the blob is in its second half
 func (a *Article) Data() ([]byte, error) {
 	// ensure we have data to read
-	if a.EntryType == RedirectEntry || a.EntryType == LinkTargetEntry || a.EntryType == DeletedEntry {
+	if a.Kind() != ContentKind {
 		return nil, nil
 	}
-	start, end, err := a.z.clusterOffsetsAtIdx(a.cluster)
+	start, end, compression, extended, err := a.clusterHeader()
 	if err != nil {
 		return nil, err
 	}
-	s, err := a.z.bytesRangeAt(start, start+1)
-	if err != nil {
-		return nil, err
-	}
-	compression := uint8(s[0])
 
 	// blob starts at offset, blob ends at offset
-	var bs, be uint32
+	var bs, be uint64
 
 	// LZMA: 4, Zstandard: 5
 	if compression == 4 || compression == 5 {
-		blobLookup := func() ([]byte, bool) {
-			if v, ok := bcache.Get(a.cluster); ok {
-				b := v.([]byte)
-				return b, ok
+		blob, ok := a.z.bcache.Get(a.cluster)
+		if !ok && (a.z.bcache.maxCount == 0 || !a.z.seen.seen(a.cluster)) {
+			// first miss on this cluster, try to only decode up to the blob
+			c, err := a.z.partialBlob(compression, start, end, uint64(a.blob), extended)
+			if err != nil || c != nil {
+				return c, err
 			}
-			return nil, false
 		}
-
-		var blob []byte
-		var ok bool
-		var dec io.ReadCloser
-		if blob, ok = blobLookup(); !ok {
-			b, err := a.z.bytesRangeAt(start+1, end+1)
+		if !ok {
+			var err error
+			blob, err = a.z.uncompressedCluster(a.cluster, compression, start, end)
 			if err != nil {
 				return nil, err
 			}
-			bbuf := bytes.NewBuffer(b)
-			switch compression {
-			case 5:
-				dec, err = NewZstdReader(bbuf)
-
-			case 4:
-				dec, err = NewXZReader(bbuf)
-			}
-			if err != nil {
-				return nil, err
-			}
-			defer dec.Close()
-			// the decoded chunk are around 1MB
-			b, err = ioutil.ReadAll(dec)
-			if err != nil {
-				return nil, err
-			}
-			blob = make([]byte, len(b))
-			copy(blob, b)
-			// TODO: 2 requests for the same blob could occure at the same time
-			bcache.Add(a.cluster, blob)
-		} else {
-			bi, ok := bcache.Get(a.cluster)
-			if !ok {
-				return nil, errors.New("not in cache anymore")
-			}
-			blob = bi.([]byte)
 		}
 
-		bs, err = readInt32(blob[a.blob*4:a.blob*4+4], nil)
-		if err != nil {
-			return nil, err
-		}
-		be, err = readInt32(blob[a.blob*4+4:a.blob*4+4+4], nil)
+		bs, be, err = blobBoundsIn(blob, uint64(a.blob), extended)
 		if err != nil {
 			return nil, err
 		}
@@ -222,27 +218,353 @@ func (a *Article) Data() ([]byte, error) {
 
 	} else if compression == 0 || compression == 1 {
 		// uncompresssed
-		startPos := start + 1
-		blobOffset := uint64(a.blob * 4)
-
-		bs, err := readInt32(a.z.bytesRangeAt(startPos+blobOffset, startPos+blobOffset+4))
+		bs, be, err = a.z.uncompressedBlob(start, end, uint64(a.blob), extended)
 		if err != nil {
 			return nil, err
 		}
 
-		be, err := readInt32(a.z.bytesRangeAt(startPos+blobOffset+4, startPos+blobOffset+4+4))
+		return a.z.bytesRangeAt(bs, be)
+	}
+
+	return nil, fmt.Errorf("%w %d", ErrUnsupportedCompression, compression)
+}
+
+// partialBlob decompresses the cluster up to the end of blob and returns the
+// blob, it returns nil when the blob is far in the cluster, a full decode
+// being then worth caching
+func (z *ZimReader) partialBlob(compression uint8, start, end, blob uint64, extended bool) ([]byte, error) {
+	dec, bs, be, err := z.seekBlob(compression, start, end, blob, extended, true)
+	if err != nil || dec == nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	// the blob size is not trusted until it's decompressed
+	c, err := io.ReadAll(io.LimitReader(dec, int64(be-bs)))
+	if err != nil {
+		return nil, z.decodeError(err)
+	}
+	if uint64(len(c)) != be-bs {
+		return nil, corruptf("truncated blob %d", blob)
+	}
+	return c, nil
+}
+
+// seekBlob returns a decoder of the cluster positioned at the start of blob
+// and the blob offsets in the uncompressed cluster
+// with firstHalf, it returns a nil decoder when the blob ends in the second
+// half of the cluster
+func (z *ZimReader) seekBlob(compression uint8, start, end, blob uint64, extended, firstHalf bool) (dec io.ReadCloser, bs, be uint64, err error) {
+	d, err := z.clusterDecoder(compression, start, end)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer func() {
+		if dec == nil {
+			d.Close()
+		}
+	}()
+
+	// the offsets table is at the start of the uncompressed cluster
+	table, err := z.readOffsetsTable(d, extended)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	offsetSize := blobOffsetSize(extended)
+	if (blob+2)*offsetSize > uint64(len(table)) {
+		return nil, 0, 0, corruptf("invalid blob number %d", blob)
+	}
+	bs, be, err = readBlobBounds(table[blob*offsetSize:], nil, extended)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	// the last offset is the size of the uncompressed cluster
+	size, err := readBlobOffset(table[uint64(len(table))-offsetSize:], extended)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if bs < uint64(len(table)) || be < bs || be > size {
+		return nil, 0, 0, corruptf("invalid blob offsets %d-%d", bs, be)
+	}
+	if firstHalf && be > size/2 {
+		return nil, 0, 0, nil
+	}
+	if z.opts.maxClusterSize > 0 && size > uint64(z.opts.maxClusterSize) {
+		return nil, 0, 0, z.clusterTooLarge()
+	}
+
+	if _, err := io.CopyN(io.Discard, d, int64(bs-uint64(len(table)))); err != nil {
+		return nil, 0, 0, z.decodeError(err)
+	}
+	return d, bs, be, nil
+}
+
+// uncompressedBlob returns the offsets in the file of blob, in the
+// uncompressed cluster between start and end
+func (z *ZimReader) uncompressedBlob(start, end, blob uint64, extended bool) (bs, be uint64, err error) {
+	offsetSize := blobOffsetSize(extended)
+	startPos := start + 1
+	size := end - start
+	if (blob+2)*offsetSize > size {
+		return 0, 0, corruptf("invalid blob number %d", blob)
+	}
+
+	b, err := z.bytesRangeAt(startPos+blob*offsetSize, startPos+(blob+2)*offsetSize)
+	bs, be, err = readBlobBounds(b, err, extended)
+	if err == nil {
+		err = checkBlobBounds(bs, be, size)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return startPos + bs, startPos + be, nil
+}
+
+// readOffsetsTable reads the blob offsets table at the start of the
+// uncompressed cluster r, the first offset being the size of the table
+func (z *ZimReader) readOffsetsTable(r io.Reader, extended bool) ([]byte, error) {
+	offsetSize := blobOffsetSize(extended)
+	first := make([]byte, offsetSize)
+	if _, err := io.ReadFull(r, first); err != nil {
+		return nil, z.decodeError(err)
+	}
+	size, err := readBlobOffset(first, extended)
+	if err != nil {
+		return nil, err
+	}
+
+	// every blob should be used by an entry
+	if size%offsetSize != 0 || size < 2*offsetSize || size > (uint64(z.ArticleCount)+1)*offsetSize {
+		return nil, corruptf("invalid blob offsets table size %d", size)
+	}
+	if z.opts.maxClusterSize > 0 && size > uint64(z.opts.maxClusterSize) {
+		return nil, z.clusterTooLarge()
+	}
+
+	table := make([]byte, size)
+	copy(table, first)
+	if _, err := io.ReadFull(r, table[offsetSize:]); err != nil {
+		return nil, z.decodeError(err)
+	}
+	return table, nil
+}
+
+// clusterHeader returns the offsets and the information of the article
+// cluster
+func (a *Article) clusterHeader() (start, end uint64, compression uint8, extended bool, err error) {
+	start, end, err = a.z.clusterOffsetsAtIdx(a.cluster)
+	if err != nil {
+		return
+	}
+	s, err := a.z.bytesRangeAt(start, start+1)
+	if err != nil {
+		return
+	}
+	compression, extended = clusterInfo(s[0])
+	return
+}
+
+// uncompressedCluster returns the uncompressed cluster from the cache or
+// decompresses it, concurrent callers share the same decompression
+func (z *ZimReader) uncompressedCluster(cluster uint32, compression uint8, start, end uint64) ([]byte, error) {
+	if b, ok := z.bcache.Get(cluster); ok {
+		return b, nil
+	}
+
+	return z.decoding.Do(cluster, func() ([]byte, error) {
+		// the cluster may have been cached while we were waiting
+		if b, ok := z.bcache.Get(cluster); ok {
+			return b, nil
+		}
+		b, err := z.decompressCluster(compression, start, end)
 		if err != nil {
 			return nil, err
 		}
+		z.bcache.Add(cluster, b)
+		return b, nil
+	})
+}
+
+// decompressCluster returns the uncompressed content of the cluster
+// between start and end, following its information byte
+func (z *ZimReader) decompressCluster(compression uint8, start, end uint64) ([]byte, error) {
+	b, err := z.bytesRangeAt(start+1, end+1)
+	if err != nil {
+		return nil, err
+	}
+
+	dec, err := z.newClusterDecoder(compression, bytes.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+	defer dec.Close()
+
+	var r io.Reader = dec
+	if z.opts.maxClusterSize > 0 {
+		// read one more byte to detect bigger clusters
+		r = io.LimitReader(dec, z.opts.maxClusterSize+1)
+	}
+
+	// the decoded chunk are around 1MB
+	b, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, z.decodeError(err)
+	}
+	if z.opts.maxClusterSize > 0 && int64(len(b)) > z.opts.maxClusterSize {
+		return nil, z.clusterTooLarge()
+	}
+
+	// avoid retaining the extra capacity of the read buffer
+	blob := make([]byte, len(b))
+	copy(blob, b)
+	return blob, nil
+}
+
+// clusterDecoder returns a reader decompressing the cluster between start
+// and end, following its information byte
+// errors reading the file are returned as is, not as decoding errors
+func (z *ZimReader) clusterDecoder(compression uint8, start, end uint64) (io.ReadCloser, error) {
+	src := &sourceReader{r: io.NewSectionReader(z.r, int64(start+1), int64(end-start))}
+	dec, err := z.newClusterDecoder(compression, bufio.NewReader(src))
+	if err != nil {
+		if src.err != nil {
+			return nil, src.err
+		}
+		return nil, err
+	}
+	return &clusterReader{ReadCloser: dec, src: src}, nil
+}
+
+// sourceReader records the errors reading the compressed cluster, the
+// decoders reporting them like any other failure
+type sourceReader struct {
+	r   io.Reader
+	err error
+}
+
+func (s *sourceReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err != nil && err != io.EOF {
+		s.err = err
+	}
+	return n, err
+}
+
+// clusterReader is a cluster decoder returning the errors reading the file
+// as sourceError
+type clusterReader struct {
+	io.ReadCloser
+	src *sourceReader
+}
+
+func (c *clusterReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if err != nil && c.src.err != nil {
+		return n, &sourceError{c.src.err}
+	}
+	return n, err
+}
+
+// sourceError is an error reading the file while decompressing a cluster
+type sourceError struct {
+	err error
+}
+
+func (e *sourceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.err
+}
+
+// newClusterDecoder returns a reader decompressing the cluster content r
+func (z *ZimReader) newClusterDecoder(compression uint8, r io.Reader) (io.ReadCloser, error) {
+	switch compression {
+	case 5:
+		var dopts []zstd.DOption
+		if z.opts.decoderConcurrency > 0 {
+			dopts = append(dopts, zstd.WithDecoderConcurrency(z.opts.decoderConcurrency))
+		}
+		if z.opts.maxClusterSize > 0 {
+			dopts = append(dopts, zstd.WithDecoderMaxMemory(uint64(z.opts.maxClusterSize)))
+		}
+		dec, err := NewZstdReader(r, dopts...)
+		if err != nil {
+			return nil, z.decodeError(err)
+		}
+		return dec, nil
 
-		return a.z.bytesRangeAt(startPos+uint64(bs), startPos+uint64(be))
+	case 4:
+		// the xz header is read when creating the decoder
+		dec, err := NewXZReader(r)
+		if err != nil {
+			return nil, z.decodeError(err)
+		}
+		return dec, nil
+	}
+	return nil, fmt.Errorf("%w %d", ErrUnsupportedCompression, compression)
+}
+
+// clusterTooLarge returns the error for clusters bigger than the maximum
+// cluster size
+func (z *ZimReader) clusterTooLarge() error {
+	return fmt.Errorf("%w, bigger than %d bytes", ErrClusterTooLarge, z.opts.maxClusterSize)
+}
+
+// decodeError qualifies an error returned while decompressing a cluster
+// errors reading the file are returned unchanged
+func (z *ZimReader) decodeError(err error) error {
+	var srcErr *sourceError
+	if errors.As(err, &srcErr) {
+		return srcErr.err
+	}
+	if errors.Is(err, zstd.ErrWindowSizeExceeded) || errors.Is(err, zstd.ErrDecoderSizeExceeded) {
+		return z.clusterTooLarge()
+	}
+	return corruptf("can't decompress cluster %w", err)
+}
+
+// blobBoundsIn returns the bounds of blob in the uncompressed cluster b
+func blobBoundsIn(b []byte, blob uint64, extended bool) (bs, be uint64, err error) {
+	offsetSize := blobOffsetSize(extended)
+	if (blob+2)*offsetSize > uint64(len(b)) {
+		err = corruptf("invalid blob number %d", blob)
+		return
+	}
+	bs, be, err = readBlobBounds(b[blob*offsetSize:], nil, extended)
+	if err == nil {
+		err = checkBlobBounds(bs, be, uint64(len(b)))
 	}
+	return
+}
 
-	return nil, errors.New("Unhandled compression")
+// checkBlobBounds validates blob bounds against the size of its cluster
+func checkBlobBounds(bs, be, size uint64) error {
+	if bs > be || be > size {
+		return corruptf("invalid blob offsets %d-%d", bs, be)
+	}
+	return nil
+}
+
+// clusterInfo decodes the cluster information byte
+// the compression type is stored in the low 4 bits, bit 4 is set for extended
+// clusters using 8 bytes blob offsets
+func clusterInfo(b byte) (compression uint8, extended bool) {
+	return b & 0x0f, b&0x10 != 0
+}
+
+// blobOffsetSize returns the size of the blob offsets of a cluster
+func blobOffsetSize(extended bool) uint64 {
+	if extended {
+		return 8
+	}
+	return 4
 }
 
 func (a *Article) MimeType() string {
-	if a.EntryType == RedirectEntry || a.EntryType == LinkTargetEntry || a.EntryType == DeletedEntry {
+	if a.Kind() != ContentKind {
 		return ""
 	}
 
@@ -255,15 +577,15 @@ func (a *Article) FullURL() string {
 }
 
 func (a *Article) String() string {
-	return fmt.Sprintf("Mime: 0x%x URL: [%s], Title: [%s], Cluster: 0x%x Blob: 0x%x",
-		a.EntryType, a.FullURL(), a.Title, a.cluster, a.blob)
+	return fmt.Sprintf("Kind: %s Mime: 0x%x URL: [%s], Title: [%s], Cluster: 0x%x Blob: 0x%x",
+		a.Kind(), a.EntryType, a.FullURL(), a.Title, a.cluster, a.blob)
 }
 
 // RedirectIndex return the redirect index of RedirectEntry type article
 // return an err if not a redirect entry
 func (a *Article) RedirectIndex() (uint32, error) {
 	if a.EntryType != RedirectEntry {
-		return 0, errors.New("Not a RedirectEntry")
+		return 0, ErrNotRedirect
 	}
 	// We use the cluster to save the redirect index position for RedirectEntry type
 	return a.cluster, nil
@@ -271,12 +593,12 @@ func (a *Article) RedirectIndex() (uint32, error) {
 
 func (a *Article) blobOffsetsAtIdx(z *ZimReader) (start, end uint64) {
 	idx := a.blob
-	offset := z.clusterPtrPos + uint64(idx)*8
+	offset := z.header.ClusterPtrPos + uint64(idx)*8
 	start, err := readInt64(z.bytesRangeAt(offset, offset+8))
 	if err != nil {
 		return
 	}
-	offset = z.clusterPtrPos + uint64(idx+1)*8
+	offset = z.header.ClusterPtrPos + uint64(idx+1)*8
 	end, _ = readInt64(z.bytesRangeAt(offset, offset+8))
 
 	return
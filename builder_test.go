@@ -0,0 +1,342 @@
+package zim
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// testEntry is a directory entry written by testZim
+type testEntry struct {
+	ns    byte
+	url   string
+	title string
+	mime  string
+	data  []byte
+	// redirect is the full url ("A/target") of the redirect target
+	redirect string
+	// front entries are listed in X/listing/titleOrdered/v1
+	front bool
+	// kind is LinkTargetEntry or DeletedEntry, 0 for content and redirects
+	kind uint16
+}
+
+// hasData returns true for entries stored in a cluster
+func (e *testEntry) hasData() bool {
+	return e.redirect == "" && e.kind == 0
+}
+
+func (e *testEntry) fullURL() string {
+	return string(e.ns) + "/" + e.url
+}
+
+func (e *testEntry) sortTitle() string {
+	if e.title == "" {
+		return e.url
+	}
+	return e.title
+}
+
+// testZim builds small ZIM files to be used as test fixtures
+type testZim struct {
+	major, minor uint16
+	// compression used for every cluster: 0 or 1 none, 4 xz, 5 zstd
+	compression uint8
+	// extended clusters use 64 bits blob offsets
+	extended bool
+	// maximum blobs count per cluster, 0 means a single cluster
+	blobsPerCluster int
+	// frontListing adds the X/listing/titleOrdered/v1 front articles listing
+	frontListing bool
+	entries      []testEntry
+}
+
+func (tz *testZim) sortedEntries() []testEntry {
+	entries := make([]testEntry, len(tz.entries))
+	copy(entries, tz.entries)
+	if tz.frontListing {
+		entries = append(entries, testEntry{ns: 'X', url: "listing/titleOrdered/v1", mime: "application/octet-stream+zimlisting"})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].ns != entries[j].ns {
+			return entries[i].ns < entries[j].ns
+		}
+		return entries[i].url < entries[j].url
+	})
+	return entries
+}
+
+// bytes returns the content of the ZIM file
+func (tz *testZim) bytes(t testing.TB) []byte {
+	t.Helper()
+
+	entries := tz.sortedEntries()
+	urlIdx := make(map[string]uint32, len(entries))
+	for i := range entries {
+		urlIdx[entries[i].fullURL()] = uint32(i)
+	}
+	if tz.frontListing {
+		tz.fillFrontListing(entries, urlIdx)
+	}
+
+	// mime types list
+	mimeIdx := make(map[string]uint16)
+	var mimes []string
+	for _, e := range entries {
+		if !e.hasData() {
+			continue
+		}
+		if _, ok := mimeIdx[e.mime]; !ok {
+			mimeIdx[e.mime] = 0
+			mimes = append(mimes, e.mime)
+		}
+	}
+	sort.Strings(mimes)
+	var mimeList bytes.Buffer
+	for i, m := range mimes {
+		mimeIdx[m] = uint16(i)
+		mimeList.WriteString(m)
+		mimeList.WriteByte(0)
+	}
+	mimeList.WriteByte(0)
+
+	// assign blobs to clusters in url order
+	var clusters [][][]byte
+	clusterOf := make([]uint32, len(entries))
+	blobOf := make([]uint32, len(entries))
+	for i, e := range entries {
+		if !e.hasData() {
+			continue
+		}
+		if len(clusters) == 0 || (tz.blobsPerCluster > 0 && len(clusters[len(clusters)-1]) >= tz.blobsPerCluster) {
+			clusters = append(clusters, nil)
+		}
+		c := len(clusters) - 1
+		clusterOf[i] = uint32(c)
+		blobOf[i] = uint32(len(clusters[c]))
+		clusters[c] = append(clusters[c], e.data)
+	}
+
+	// title index
+	titleOrder := make([]uint32, len(entries))
+	for i := range titleOrder {
+		titleOrder[i] = uint32(i)
+	}
+	sort.SliceStable(titleOrder, func(i, j int) bool {
+		a, b := entries[titleOrder[i]], entries[titleOrder[j]]
+		if a.ns != b.ns {
+			return a.ns < b.ns
+		}
+		return a.sortTitle() < b.sortTitle()
+	})
+
+	// directory entries
+	dirents := make([][]byte, len(entries))
+	for i, e := range entries {
+		var d bytes.Buffer
+		switch {
+		case e.kind != 0:
+			writeLE(&d, e.kind)
+			d.Write([]byte{0, e.ns})
+			writeLE(&d, uint32(0))
+		case e.redirect != "":
+			target, ok := urlIdx[e.redirect]
+			if !ok {
+				t.Fatalf("unknown redirect target %s", e.redirect)
+			}
+			writeLE(&d, RedirectEntry)
+			d.Write([]byte{0, e.ns})
+			writeLE(&d, uint32(0))
+			writeLE(&d, target)
+		default:
+			writeLE(&d, mimeIdx[e.mime])
+			d.Write([]byte{0, e.ns})
+			writeLE(&d, uint32(0))
+			writeLE(&d, clusterOf[i])
+			writeLE(&d, blobOf[i])
+		}
+		d.WriteString(e.url)
+		d.WriteByte(0)
+		d.WriteString(e.title)
+		d.WriteByte(0)
+		dirents[i] = d.Bytes()
+	}
+
+	clusterData := make([][]byte, len(clusters))
+	for i, blobs := range clusters {
+		clusterData[i] = tz.cluster(t, blobs)
+	}
+
+	// layout: header, mime list, url pointers, title pointers, dirents,
+	// cluster pointers, clusters, checksum
+	const headerSize = 80
+	mimeListPos := uint64(headerSize)
+	urlPtrPos := mimeListPos + uint64(mimeList.Len())
+	titlePtrPos := urlPtrPos + uint64(len(entries))*8
+	direntPos := titlePtrPos + uint64(len(entries))*4
+	pos := direntPos
+	direntOffsets := make([]uint64, len(entries))
+	for i, d := range dirents {
+		direntOffsets[i] = pos
+		pos += uint64(len(d))
+	}
+	clusterPtrPos := pos
+	pos += uint64(len(clusters)) * 8
+	clusterOffsets := make([]uint64, len(clusters))
+	for i, c := range clusterData {
+		clusterOffsets[i] = pos
+		pos += uint64(len(c))
+	}
+	checksumPos := pos
+
+	var f bytes.Buffer
+	writeLE(&f, uint32(zimHeader))
+	writeLE(&f, tz.major)
+	writeLE(&f, tz.minor)
+	f.Write(bytes.Repeat([]byte{0x42}, 16))
+	writeLE(&f, uint32(len(entries)))
+	writeLE(&f, uint32(len(clusters)))
+	writeLE(&f, urlPtrPos)
+	writeLE(&f, titlePtrPos)
+	writeLE(&f, clusterPtrPos)
+	writeLE(&f, mimeListPos)
+	writeLE(&f, uint32(0xffffffff))
+	writeLE(&f, uint32(0xffffffff))
+	writeLE(&f, checksumPos)
+	f.Write(mimeList.Bytes())
+	for _, o := range direntOffsets {
+		writeLE(&f, o)
+	}
+	for _, idx := range titleOrder {
+		writeLE(&f, idx)
+	}
+	for _, d := range dirents {
+		f.Write(d)
+	}
+	for _, o := range clusterOffsets {
+		writeLE(&f, o)
+	}
+	for _, c := range clusterData {
+		f.Write(c)
+	}
+	sum := md5.Sum(f.Bytes())
+	f.Write(sum[:])
+
+	return f.Bytes()
+}
+
+// fillFrontListing sets the listing data: the url indexes of the front entries
+// ordered by title
+func (tz *testZim) fillFrontListing(entries []testEntry, urlIdx map[string]uint32) {
+	var front []uint32
+	for i, e := range entries {
+		if e.front {
+			front = append(front, uint32(i))
+		}
+	}
+	sort.SliceStable(front, func(i, j int) bool {
+		return entries[front[i]].sortTitle() < entries[front[j]].sortTitle()
+	})
+
+	var b bytes.Buffer
+	for _, idx := range front {
+		writeLE(&b, idx)
+	}
+	entries[urlIdx["X/listing/titleOrdered/v1"]].data = b.Bytes()
+}
+
+// cluster returns the info byte followed by the maybe compressed blobs
+func (tz *testZim) cluster(t testing.TB, blobs [][]byte) []byte {
+	t.Helper()
+
+	offsetSize := 4
+	info := tz.compression
+	if tz.extended {
+		offsetSize = 8
+		info |= 0x10
+	}
+
+	var raw bytes.Buffer
+	offset := uint64((len(blobs) + 1) * offsetSize)
+	offsets := []uint64{offset}
+	for _, b := range blobs {
+		offset += uint64(len(b))
+		offsets = append(offsets, offset)
+	}
+	for _, o := range offsets {
+		if tz.extended {
+			writeLE(&raw, o)
+		} else {
+			writeLE(&raw, uint32(o))
+		}
+	}
+	for _, b := range blobs {
+		raw.Write(b)
+	}
+
+	var c bytes.Buffer
+	c.WriteByte(info)
+	switch tz.compression {
+	case 0, 1:
+		c.Write(raw.Bytes())
+	case 4:
+		w, err := xz.NewWriter(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(raw.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	case 5:
+		w, err := zstd.NewWriter(&c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(raw.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("unsupported test compression %d", tz.compression)
+	}
+	return c.Bytes()
+}
+
+// write writes the ZIM file in a temporary directory and returns its path
+func (tz *testZim) write(t testing.TB) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "fixture.zim")
+	if err := os.WriteFile(path, tz.bytes(t), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// open writes then opens the ZIM file, closing it at the end of the test
+func (tz *testZim) open(t testing.TB, mmap bool) *ZimReader {
+	t.Helper()
+
+	z, err := NewReader(tz.write(t), mmap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { z.Close() })
+	return z
+}
+
+func writeLE(b *bytes.Buffer, v interface{}) {
+	_ = binary.Write(b, binary.LittleEndian, v)
+}
@@ -0,0 +1,110 @@
+package zim
+
+import (
+	"container/list"
+	"sync"
+)
+
+// clusterCache is a LRU cache of uncompressed clusters bounded by a count
+// and a byte budget
+type clusterCache struct {
+	mu       sync.Mutex
+	maxCount int
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[uint32]*list.Element
+}
+
+type clusterCacheEntry struct {
+	cluster uint32
+	data    []byte
+}
+
+// newClusterCache returns a cache keeping up to maxCount clusters and up to
+// maxBytes bytes, 0 maxBytes for no byte limit
+func newClusterCache(maxCount int, maxBytes int64) *clusterCache {
+	return &clusterCache{
+		maxCount: maxCount,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[uint32]*list.Element),
+	}
+}
+
+// Get returns the uncompressed cluster if present
+func (c *clusterCache) Get(cluster uint32) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[cluster]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return e.Value.(*clusterCacheEntry).data, true
+}
+
+// Add stores an uncompressed cluster, evicting the least recently used ones
+// clusters bigger than the whole byte budget are not stored
+func (c *clusterCache) Add(cluster uint32, data []byte) {
+	size := int64(len(data))
+	if c.maxCount == 0 || (c.maxBytes > 0 && size > c.maxBytes) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[cluster]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*clusterCacheEntry)
+		c.bytes += size - int64(len(entry.data))
+		entry.data = data
+	} else {
+		c.items[cluster] = c.ll.PushFront(&clusterCacheEntry{cluster: cluster, data: data})
+		c.bytes += size
+	}
+
+	for c.ll.Len() > c.maxCount || (c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeOldest()
+	}
+}
+
+// seen returns true if the cluster is present, adding it otherwise, used to
+// remember the clusters recently requested without their data
+func (c *clusterCache) seen(cluster uint32) bool {
+	if _, ok := c.Get(cluster); ok {
+		return true
+	}
+	c.Add(cluster, nil)
+	return false
+}
+
+// Purge empties the cache
+func (c *clusterCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ll.Init()
+	c.items = make(map[uint32]*list.Element)
+	c.bytes = 0
+}
+
+// Len returns the number of cached clusters
+func (c *clusterCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+func (c *clusterCache) removeOldest() {
+	e := c.ll.Back()
+	if e == nil {
+		return
+	}
+	entry := c.ll.Remove(e).(*clusterCacheEntry)
+	delete(c.items, entry.cluster)
+	c.bytes -= int64(len(entry.data))
+}
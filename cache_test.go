@@ -0,0 +1,60 @@
+package zim
+
+import "testing"
+
+func TestClusterCacheCount(t *testing.T) {
+	c := newClusterCache(2, 0)
+	c.Add(1, []byte("one"))
+	c.Add(2, []byte("two"))
+	c.Get(1)
+	c.Add(3, []byte("three"))
+
+	if _, ok := c.Get(2); ok {
+		t.Error("least recently used cluster 2 should be evicted")
+	}
+	if b, ok := c.Get(1); !ok || string(b) != "one" {
+		t.Error("cluster 1 should be cached")
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 cached clusters got %d", c.Len())
+	}
+
+	c.Purge()
+	if c.Len() != 0 || c.bytes != 0 {
+		t.Error("cache should be empty after purge")
+	}
+}
+
+func TestClusterCacheBytes(t *testing.T) {
+	c := newClusterCache(10, 10)
+	c.Add(1, make([]byte, 4))
+	c.Add(2, make([]byte, 4))
+	c.Add(3, make([]byte, 4))
+
+	if _, ok := c.Get(1); ok {
+		t.Error("cluster 1 should be evicted to fit the byte budget")
+	}
+	if c.Len() != 2 || c.bytes != 8 {
+		t.Errorf("expected 2 clusters for 8 bytes got %d for %d", c.Len(), c.bytes)
+	}
+
+	// bigger than the whole budget
+	c.Add(4, make([]byte, 11))
+	if _, ok := c.Get(4); ok {
+		t.Error("cluster bigger than the budget should not be cached")
+	}
+
+	// replacing an entry updates the size
+	c.Add(2, make([]byte, 6))
+	if c.bytes != 10 {
+		t.Errorf("expected 10 bytes got %d", c.bytes)
+	}
+}
+
+func TestClusterCacheDisabled(t *testing.T) {
+	c := newClusterCache(0, 0)
+	c.Add(1, []byte("one"))
+	if _, ok := c.Get(1); ok {
+		t.Error("a zero count cache should not store anything")
+	}
+}
@@ -0,0 +1,64 @@
+package zim
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+)
+
+// verifyChunkSize is the size of the chunks read while verifying a file
+const verifyChunkSize = 1 << 20
+
+// Checksum returns the MD5 checksum stored at the end of the ZIM file
+func (z *ZimReader) Checksum() ([md5.Size]byte, error) {
+	var sum [md5.Size]byte
+	if !z.header.HasChecksum() {
+		return sum, ErrNoChecksum
+	}
+
+	b, err := z.bytesRangeAt(z.header.ChecksumPos, z.header.ChecksumPos+md5.Size)
+	if err != nil {
+		return sum, err
+	}
+	copy(sum[:], b)
+	return sum, nil
+}
+
+// Verify streams the file up to the checksum position and compares its MD5 with
+// the stored checksum, returning ErrChecksumMismatch if they differ
+// progress is optional and called after every chunk with the number of bytes
+// hashed so far and the total to hash
+func (z *ZimReader) Verify(ctx context.Context, progress func(done, total uint64)) error {
+	expected, err := z.Checksum()
+	if err != nil {
+		return err
+	}
+
+	total := z.header.ChecksumPos
+	h := md5.New()
+	for pos := uint64(0); pos < total; {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		end := pos + verifyChunkSize
+		if end > total {
+			end = total
+		}
+		b, err := z.bytesRangeAt(pos, end)
+		if err != nil {
+			return err
+		}
+		h.Write(b)
+		pos = end
+
+		if progress != nil {
+			progress(pos, total)
+		}
+	}
+
+	if !bytes.Equal(h.Sum(nil), expected[:]) {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
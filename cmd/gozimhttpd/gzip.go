@@ -2,23 +2,71 @@ package main
 
 import (
 	"compress/gzip"
-	"io"
+	"mime"
 	"net/http"
 	"strings"
 )
 
+// gzipResponseWriter compresses the response only if its content type is
+// compressible, the decision is made when the header is written
 type gzipResponseWriter struct {
-	io.Writer
 	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
 }
 
-func (w gzipResponseWriter) Write(b []byte) (int, error) {
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	h := w.Header()
+	if code == http.StatusOK && h.Get("Content-Encoding") == "" && isCompressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
 	if "" == w.Header().Get("Content-Type") {
 		// If no content type, apply sniffing algorithm to un-gzipped body.
 		w.Header().Set("Content-Type", http.DetectContentType(b))
 	}
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	return w.gz.Close()
+}
 
-	return w.Writer.Write(b)
+// isCompressible returns true for textual content types, images, videos and
+// archives are already compressed
+func isCompressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mt, "text/") {
+		return true
+	}
+	switch mt {
+	case "application/javascript", "application/json", "application/xml",
+		"application/xhtml+xml", "image/svg+xml":
+		return true
+	}
+	return false
 }
 
 func makeGzipHandler(fn http.HandlerFunc) http.HandlerFunc {
@@ -28,10 +76,9 @@ func makeGzipHandler(fn http.HandlerFunc) http.HandlerFunc {
 
 			return
 		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-		gzr := gzipResponseWriter{Writer: gz, ResponseWriter: w}
+		w.Header().Add("Vary", "Accept-Encoding")
+		gzr := &gzipResponseWriter{ResponseWriter: w}
+		defer gzr.Close()
 		fn(gzr, r)
 	}
 }
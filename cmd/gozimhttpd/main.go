@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"flag"
 	"html/template"
@@ -10,6 +11,7 @@ import (
 	"os/signal"
 	"runtime/pprof"
 	"strconv"
+	"strings"
 
 	zim "github.com/akhenakh/gozim"
 	"github.com/blevesearch/bleve"
@@ -34,6 +36,10 @@ const (
 	RedirectResponse ResponseType = iota
 	DataResponse
 	NoResponse
+	// StreamResponse are large media read from the zim on every request
+	StreamResponse
+	// ErrorResponse are failures other than missing entries
+	ErrorResponse
 )
 
 // CachedResponse cache the answer to an URL in the zim
@@ -41,16 +47,23 @@ type CachedResponse struct {
 	ResponseType ResponseType
 	Data         []byte
 	MimeType     string
+	// Status is the HTTP status of an ErrorResponse
+	Status int
 }
 
 var (
 	port       = flag.Int("port", -1, "port to listen to, read HOST env if not specified, default to 8080 otherwise")
-	zimPath    = flag.String("path", "", "path for the zim file")
+	zimPath    = flag.String("path", "", "path or http url for the zim file")
 	indexPath  = flag.String("index", "", "path for the index file")
 	mmap       = flag.Bool("mmap", false, "use mmap")
+	verify     = flag.Bool("verify", false, "verify the zim file checksum before serving")
+	cacheCount = flag.Int("clustercache", zim.DefaultClusterCacheCount, "number of uncompressed clusters kept in cache")
+	cacheMB    = flag.Int64("clustercachemb", 0, "maximum size in MB of the uncompressed clusters cache, 0 for no limit")
 	cpuprofile = flag.String("cpuprofile", "", "write cpu profile to file")
 
 	Z *zim.ZimReader
+	// metadata of the zim file, read once at start
+	metadata *zim.Metadata
 	// Cache is filled with CachedResponse to avoid hitting the zim file for a zim URL
 	cache *lru.ARCCache
 	idx   bool
@@ -123,15 +136,41 @@ func main() {
 
 	// compress wiki pages
 	http.HandleFunc("/zim/", makeGzipHandler(zimHandler))
-	z, err := zim.NewReader(*zimPath, *mmap)
+	z, err := openZim(*zimPath,
+		zim.WithMmap(*mmap),
+		zim.WithClusterCacheCount(*cacheCount),
+		zim.WithClusterCacheBytes(*cacheMB<<20),
+	)
 	Z = z
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	if *verify {
+		log.Println("Verifying", *zimPath)
+		lastPct := -1
+		err = Z.Verify(context.Background(), func(done, total uint64) {
+			if pct := int(done * 100 / total); pct/10 != lastPct/10 {
+				log.Printf("%d%% verified\n", pct)
+				lastPct = pct
+			}
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	metadata, err = Z.Metadata()
+	if err != nil {
+		log.Println("can't read metadata", err)
+		metadata = &zim.Metadata{}
+	}
+
 	// tpl
 	http.HandleFunc("/search/", makeGzipHandler(searchHandler))
+	http.HandleFunc("/suggest/", makeGzipHandler(suggestHandler))
 	http.HandleFunc("/browse/", makeGzipHandler(browseHandler))
+	http.HandleFunc("/random/", randomHandler)
 	http.HandleFunc("/about/", makeGzipHandler(aboutHandler))
 	http.HandleFunc("/robots.txt", robotHandler)
 	http.HandleFunc("/", makeGzipHandler(homeHandler))
@@ -160,3 +199,16 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// openZim opens a local zim file or a remote one over http range requests
+func openZim(path string, opts ...zim.Option) (*zim.ZimReader, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return zim.NewReaderWithOptions(path, opts...)
+	}
+
+	h, err := zim.NewHTTPReaderAt(path)
+	if err != nil {
+		return nil, err
+	}
+	return zim.NewReaderFromReaderAt(h, h.Size(), opts...)
+}
@@ -1,18 +1,28 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
 	"path"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	zim "github.com/akhenakh/gozim"
 	"github.com/blevesearch/bleve"
 )
 
 const (
-	ArticlesPerPage = 16
+	ArticlesPerPage  = 16
+	SuggestionsCount = 10
+	// MaxPrefixTitles limits the titles read by a search without index
+	MaxPrefixTitles = 1000
 )
 
 func cacheLookup(url string) (*CachedResponse, bool) {
@@ -39,9 +49,99 @@ func handleCachedResponse(cr *CachedResponse, w http.ResponseWriter, r *http.Req
 		// 15 days
 		w.Header().Set("Cache-control", "public, max-age=1350000")
 		w.Write(cr.Data)
+	} else if cr.ResponseType == StreamResponse {
+		serveStream(w, r, r.URL.Path[5:], cr.MimeType)
+	} else if cr.ResponseType == ErrorResponse {
+		log.Printf("%d %s\n", cr.Status, r.URL.Path)
+		http.Error(w, http.StatusText(cr.Status), cr.Status)
 	}
 }
 
+// errorStatus returns the HTTP status code matching a zim error
+func errorStatus(err error) int {
+	var loopErr *zim.RedirectLoopError
+	switch {
+	case errors.Is(err, zim.ErrNotFound), errors.Is(err, zim.ErrNoData):
+		return http.StatusNotFound
+	case errors.Is(err, zim.ErrTooManyRedirects), errors.As(err, &loopErr):
+		return http.StatusLoopDetected
+	case errors.Is(err, zim.ErrUnsupportedCompression):
+		return http.StatusNotImplemented
+	}
+	return http.StatusInternalServerError
+}
+
+// serveError responds with the status matching err
+func serveError(w http.ResponseWriter, r *http.Request, err error) {
+	status := errorStatus(err)
+	log.Printf("%d %s: %v\n", status, r.URL.Path, err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// isPermanent returns true for the errors reading the same url will
+// always return, transient errors like a failed network read are not
+func isPermanent(err error) bool {
+	var loopErr *zim.RedirectLoopError
+	return errors.Is(err, zim.ErrNotFound) || errors.Is(err, zim.ErrNoData) ||
+		errors.Is(err, zim.ErrCorrupt) || errors.Is(err, zim.ErrUnsupportedCompression) ||
+		errors.Is(err, zim.ErrTooManyRedirects) || errors.As(err, &loopErr)
+}
+
+// cacheError caches the response to a failed lookup, it returns false when
+// the error is transient and should be served without caching
+func cacheError(url string, err error) bool {
+	if !isPermanent(err) {
+		return false
+	}
+	status := errorStatus(err)
+	if status == http.StatusNotFound {
+		cache.Add(url, CachedResponse{ResponseType: NoResponse})
+
+		return true
+	}
+	log.Printf("error reading %s: %v\n", url, err)
+	cache.Add(url, CachedResponse{ResponseType: ErrorResponse, Status: status})
+
+	return true
+}
+
+// isStreamed returns true for the media types too large to be kept in the
+// response cache
+func isStreamed(mimeType string) bool {
+	if strings.HasPrefix(mimeType, "video/") || strings.HasPrefix(mimeType, "audio/") {
+		return true
+	}
+	switch mimeType {
+	case "application/pdf", "application/epub+zip", "application/zip":
+		return true
+	}
+	return false
+}
+
+// serveStream serves a blob without loading it in memory, supporting range
+// requests to seek in videos
+func serveStream(w http.ResponseWriter, r *http.Request, url, mimeType string) {
+	a, err := Z.GetPageNoIndex(url)
+	if err != nil {
+		serveError(w, r, err)
+
+		return
+	}
+	rs, err := a.Open()
+	if err != nil {
+		serveError(w, r, err)
+
+		return
+	}
+	defer rs.Close()
+
+	log.Printf("200 %s\n", r.URL.Path)
+	w.Header().Set("Content-Type", mimeType)
+	// 15 days
+	w.Header().Set("Cache-control", "public, max-age=1350000")
+	http.ServeContent(w, r, "", time.Time{}, rs)
+}
+
 // the handler receiving http request
 func zimHandler(w http.ResponseWriter, r *http.Request) {
 	url := r.URL.Path[5:]
@@ -51,30 +151,41 @@ func zimHandler(w http.ResponseWriter, r *http.Request) {
 
 		return
 	} else {
-		var a *zim.Article
-		a, _ = Z.GetPageNoIndex(url)
+		a, err := Z.GetPageNoIndex(url)
+		// failure is the transient error to serve without caching
+		var failure error
 
-		if a == nil {
+		if err != nil {
+			if !cacheError(url, err) {
+				failure = err
+			}
+		} else if a.Kind() == zim.LinkTargetKind || a.Kind() == zim.DeletedKind {
 			cache.Add(url, CachedResponse{ResponseType: NoResponse})
-		} else if a.EntryType == zim.RedirectEntry {
-			ridx, err := a.RedirectIndex()
+		} else if a.Kind() == zim.RedirectKind {
+			// redirect straight to the end of the redirect chain
+			ra, err := Z.Resolve(a)
 			if err != nil {
-				cache.Add(url, CachedResponse{ResponseType: NoResponse})
-			} else {
-				ra, err := Z.ArticleAtURLIdx(ridx)
-				if err != nil {
-					cache.Add(url, CachedResponse{ResponseType: NoResponse})
-				} else {
-					cache.Add(url, CachedResponse{
-						ResponseType: RedirectResponse,
-						Data:         []byte(ra.FullURL()),
-					})
+				log.Printf("can't resolve %s: %v\n", url, err)
+				if !cacheError(url, err) {
+					failure = err
 				}
+			} else {
+				cache.Add(url, CachedResponse{
+					ResponseType: RedirectResponse,
+					Data:         []byte(ra.FullURL()),
+				})
 			}
+		} else if isStreamed(a.MimeType()) {
+			cache.Add(url, CachedResponse{
+				ResponseType: StreamResponse,
+				MimeType:     a.MimeType(),
+			})
 		} else {
 			data, err := a.Data()
 			if err != nil {
-				cache.Add(url, CachedResponse{ResponseType: NoResponse})
+				if !cacheError(url, err) {
+					failure = err
+				}
 			} else {
 				cache.Add(url, CachedResponse{
 					ResponseType: DataResponse,
@@ -84,6 +195,12 @@ func zimHandler(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		if failure != nil {
+			serveError(w, r, failure)
+
+			return
+		}
+
 		// look again in the cache for the same entry
 		if cr, iscached := cacheLookup(url); iscached {
 			handleCachedResponse(cr, w, r)
@@ -105,14 +222,22 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 	mainPage, err := Z.MainPage()
 	var hasMainPage bool
 
-	if err != nil && mainPage != nil {
+	if err == nil && mainPage != nil {
 		hasMainPage = true
 		mainURL = "/zim/" + mainPage.FullURL()
 	}
 
+	count, err := Z.FrontArticleCount()
+	if err != nil {
+		count = Z.ArticleCount
+	}
+
 	d := map[string]interface{}{
 		"Path":        path.Base(*zimPath),
-		"Count":       strconv.Itoa(int(Z.ArticleCount)),
+		"Title":       metadata.Title,
+		"Description": metadata.Description,
+		"Language":    metadata.Language,
+		"Count":       strconv.Itoa(int(count)),
 		"IsIndexed":   idx,
 		"HasMainPage": hasMainPage,
 		"MainURL":     mainURL,
@@ -137,11 +262,17 @@ func aboutHandler(w http.ResponseWriter, r *http.Request) {
 func searchHandler(w http.ResponseWriter, r *http.Request) {
 	pageString := r.FormValue("page")
 	pageNumber, _ := strconv.Atoi(pageString)
+	if pageNumber < 0 {
+		http.Error(w, "invalid page", http.StatusBadRequest)
+
+		return
+	}
 	previousPage := pageNumber - 1
 	if pageNumber == 0 {
 		previousPage = 0
 	}
 	nextPage := pageNumber + 1
+	itemCount := 20
 	q := r.FormValue("search_data")
 	d := map[string]interface{}{
 		"Query":        q,
@@ -151,7 +282,42 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		"NextPage":     nextPage,
 	}
 
+	// jump straight to the article matching the exact title
+	if q != "" && pageNumber == 0 {
+		if a, err := Z.GetPageByTitle(Z.MainNamespace(), q); err == nil {
+			http.Redirect(w, r, "/zim/"+a.FullURL(), http.StatusFound)
+
+			return
+		}
+	}
+
 	if !idx {
+		if q != "" {
+			if pageNumber >= MaxPrefixTitles/itemCount {
+				http.Error(w, "page out of range", http.StatusBadRequest)
+
+				return
+			}
+
+			// no index, only look for titles starting with the query
+			articles, err := titlesWithPrefix(q, itemCount*(pageNumber+1))
+			if err != nil {
+				http.Error(w, err.Error(), 500)
+
+				return
+			}
+
+			var l []map[string]string
+			for i := itemCount * pageNumber; i < len(articles); i++ {
+				l = append(l, map[string]string{
+					"Title": articles[i].Title,
+					"URL":   "/zim/" + articles[i].FullURL(),
+				})
+			}
+			d["Hits"] = l
+			d["Info"] = fmt.Sprintf("%d titles starting with [%s]", len(l), q)
+		}
+
 		if err := templates.ExecuteTemplate(w, "searchNoIdx.html", d); err != nil {
 			http.Error(w, err.Error(), 500)
 		}
@@ -167,7 +333,6 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	itemCount := 20
 	from := itemCount * pageNumber
 	query := bleve.NewQueryStringQuery(q)
 	search := bleve.NewSearchRequestOptions(query, itemCount, from, false)
@@ -228,24 +393,35 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 		page, _ = strconv.Atoi(p)
 	}
 
-	if page*ArticlesPerPage-1 >= int(Z.ArticleCount) {
+	// only browse the front articles, ordered by title
+	count, err := Z.FrontArticleCount()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+
+		return
+	}
+
+	first := page * ArticlesPerPage
+	if page < 0 || (first >= int(count) && page > 0) {
 		http.NotFound(w, r)
 
 		return
 	}
 
-	Articles := make([]*zim.Article, ArticlesPerPage)
-	var pos int
-	for i := page * ArticlesPerPage; i < page*ArticlesPerPage+ArticlesPerPage; i++ {
-		a, err := Z.ArticleAtURLIdx(uint32(i))
+	Articles := make([]*zim.Article, 0, ArticlesPerPage)
+	for i := first; i < first+ArticlesPerPage && i < int(count); i++ {
+		idx, err := Z.FrontArticleIdxAt(uint32(i))
+		if err != nil {
+			continue
+		}
+		a, err := Z.ArticleAtURLIdx(idx)
 		if err != nil {
 			continue
 		}
 		if a.Title == "" {
 			a.Title = a.FullURL()
 		}
-		Articles[pos] = a
-		pos++
+		Articles = append(Articles, a)
 	}
 
 	if page == 0 {
@@ -254,7 +430,7 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 		previousPage = page - 1
 	}
 
-	if page*ArticlesPerPage-1 >= int(Z.ArticleCount) {
+	if first+ArticlesPerPage >= int(count) {
 		nextPage = page
 	} else {
 		nextPage = page + 1
@@ -274,6 +450,79 @@ func browseHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// suggestHandler returns a JSON list of articles with a title starting with term
+// to be used for autocompletion
+func suggestHandler(w http.ResponseWriter, r *http.Request) {
+	term := r.FormValue("term")
+	l := []map[string]string{}
+
+	if term != "" {
+		articles, err := titlesWithPrefix(term, SuggestionsCount)
+		if err != nil {
+			http.Error(w, err.Error(), 500)
+
+			return
+		}
+		for _, a := range articles {
+			l = append(l, map[string]string{
+				"Title": a.Title,
+				"URL":   "/zim/" + a.FullURL(),
+			})
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		log.Println(err)
+	}
+}
+
+// titlesWithPrefix looks for articles titles starting with prefix, retrying
+// with a capitalized prefix since most titles start with an upper case
+func titlesWithPrefix(prefix string, limit int) ([]*zim.Article, error) {
+	articles, err := Z.TitlesWithPrefix(Z.MainNamespace(), prefix, limit)
+	if err != nil || len(articles) > 0 {
+		return articles, err
+	}
+
+	r, size := utf8.DecodeRuneInString(prefix)
+	if upper := unicode.ToUpper(r); upper != r {
+		return Z.TitlesWithPrefix(Z.MainNamespace(), string(upper)+prefix[size:], limit)
+	}
+
+	return articles, nil
+}
+
+// randomHandler redirects to a random front article
+func randomHandler(w http.ResponseWriter, r *http.Request) {
+	count, err := Z.FrontArticleCount()
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+
+		return
+	}
+	if count == 0 {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	idx, err := Z.FrontArticleIdxAt(uint32(rand.Int63n(int64(count))))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+
+		return
+	}
+	a, err := Z.ArticleAtURLIdx(idx)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+
+		return
+	}
+
+	http.Redirect(w, r, "/zim/"+a.FullURL(), http.StatusFound)
+}
+
 func robotHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, "User-agent: *\nDisallow: /\n")
 }
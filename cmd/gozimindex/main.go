@@ -2,9 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"runtime"
 
 	"github.com/PuerkitoBio/goquery"
 	zim "github.com/akhenakh/gozim"
@@ -28,6 +30,8 @@ var (
 	lang         = flag.String("lang", "", "language for indexation")
 	batchSize    = flag.Int("batchsize", 1000, "size of bleve batches")
 	indexContent = flag.Bool("content", false, "expermintal: index the content of the page")
+	workers      = flag.Int("workers", runtime.NumCPU(), "number of clusters decompressed concurrently while indexing the content")
+	memoryMB     = flag.Int64("memorymb", 512, "maximum size in MB of the decompressed clusters waiting to be indexed")
 )
 
 // Type return the Article type (used for bleve indexer)
@@ -88,58 +92,86 @@ func main() {
 		log.Fatal(err)
 	}
 
-	i := 0
-
 	batch := index.NewBatch()
 	batchCount := 0
-	idoc := ArticleIndex{}
 
-	divisor := float64(z.ArticleCount) / 100
+	// add a document to the batch, sending full batches to bleve
+	add := func(idx uint32, idoc ArticleIndex) {
+		// index the idoc with the idx as key
+		batch.Index(fmt.Sprint(idx), idoc)
+		batchCount++
+
+		if batchCount >= *batchSize {
+			err := index.Batch(batch)
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			batch = index.NewBatch()
+			batchCount = 0
+		}
+	}
+
+	count, err := z.FrontArticleCount()
+	if err != nil {
+		log.Fatal(err)
+	}
+	divisor := float64(count) / 100
+
+	if *indexContent {
+		// read the pages in cluster order, decompressing every cluster once
+		i := 0
+		err = z.WalkClustersParallel(context.Background(), []byte{z.MainNamespace()}, *workers, *memoryMB<<20, func(idx uint32, a *zim.Article, data []byte) error {
+			front, err := z.IsFrontArticle(idx)
+			if err != nil || !front {
+				return err
+			}
+			if i%*batchSize == 0 {
+				fmt.Printf("%.2f%% content done\n", float64(i)/divisor)
+			}
+			i++
+
+			doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			add(idx, ArticleIndex{Title: a.Title, Content: doc.Text()})
+			return nil
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	i := 0
 
-	z.ListTitlesPtrIterator(func(idx uint32) {
+	// only index front articles, skipping resources like css or images
+	err = z.ListFrontArticlesIterator(func(idx uint32) {
 		if i%*batchSize == 0 {
 			fmt.Printf("%.2f%% done\n", float64(i)/divisor)
 		}
+		i++
+
 		a, err := z.ArticleAtURLIdx(idx)
-		if err != nil || a.EntryType == zim.DeletedEntry {
-			i++
+		if err != nil || a.Kind() == zim.DeletedKind || a.Kind() == zim.LinkTargetKind {
 			return
 		}
-
-		if a.Namespace == 'A' {
-			idoc.Title = a.Title
-			// index the idoc with the idx as key
-			if *indexContent {
-				data, err := a.Data()
-				if err != nil {
-					log.Fatal(err.Error())
-				}
-
-				r := bytes.NewReader(data)
-				doc, err := goquery.NewDocumentFromReader(r)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				idoc.Content = doc.Text()
-			}
-			batch.Index(fmt.Sprint(idx), idoc)
-
-			batchCount++
+		// pages were already indexed with their content
+		if *indexContent && a.Kind() == zim.ContentKind {
+			return
 		}
 
-		i++
+		front, err := z.IsFrontArticle(idx)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
 
-		// send a batch to bleve
-		if batchCount >= *batchSize {
-			err = index.Batch(batch)
-			if err != nil {
-				log.Fatal(err.Error())
-			}
-			batch = index.NewBatch()
-			batchCount = 0
+		if front {
+			add(idx, ArticleIndex{Title: a.Title})
 		}
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// batch the rest
 	if batchCount > 0 {
@@ -0,0 +1,267 @@
+package zim
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"sort"
+)
+
+// idxRange is a [start, end) range of positions in an index
+type idxRange struct {
+	start, end uint32
+}
+
+// namespaceRanges returns the ranges of the namespaces in the index searched
+// by search, sorted by namespace, the whole index when namespaces is empty
+func (z *ZimReader) namespaceRanges(namespaces []byte, search func(f func(a *Article) bool) (uint32, error)) ([]idxRange, error) {
+	if len(namespaces) == 0 {
+		return []idxRange{{0, z.ArticleCount}}, nil
+	}
+
+	namespaces = slices.Clone(namespaces)
+	slices.Sort(namespaces)
+	namespaces = slices.Compact(namespaces)
+
+	ranges := make([]idxRange, 0, len(namespaces))
+	for _, ns := range namespaces {
+		start, err := search(func(a *Article) bool {
+			return a.Namespace >= ns
+		})
+		if err != nil {
+			return nil, err
+		}
+		end, err := search(func(a *Article) bool {
+			return a.Namespace > ns
+		})
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, idxRange{start, end})
+	}
+	return ranges, nil
+}
+
+// walkIndex calls yield with the entries of the index searched by search,
+// restricted to namespaces if any, idxAt returns the URL index of an index
+// position, deleted entries are skipped
+func (z *ZimReader) walkIndex(ctx context.Context, namespaces []byte,
+	search func(f func(a *Article) bool) (uint32, error), idxAt func(pos uint32) (uint32, error),
+	yield func(idx uint32, a *Article, err error) bool) {
+	ranges, err := z.namespaceRanges(namespaces, search)
+	if err != nil {
+		yield(0, nil, err)
+		return
+	}
+
+	for _, r := range ranges {
+		for pos := r.start; pos < r.end; pos++ {
+			if err := ctx.Err(); err != nil {
+				yield(0, nil, err)
+				return
+			}
+			idx, err := idxAt(pos)
+			if err != nil {
+				yield(0, nil, err)
+				return
+			}
+			a, err := z.ArticleAtURLIdx(idx)
+			if err != nil {
+				yield(idx, nil, err)
+				return
+			}
+			if a.Kind() == DeletedKind {
+				continue
+			}
+			if !yield(idx, a, nil) {
+				return
+			}
+		}
+	}
+}
+
+// urlIdxAt returns pos, the URL index being its own position
+func urlIdxAt(pos uint32) (uint32, error) {
+	return pos, nil
+}
+
+// EntriesByURL returns an iterator over the entries in URL order, restricted
+// to namespaces if any, deleted entries are skipped
+// iteration stops after yielding an error
+func (z *ZimReader) EntriesByURL(ctx context.Context, namespaces ...byte) iter.Seq2[*Article, error] {
+	return func(yield func(*Article, error) bool) {
+		z.walkIndex(ctx, namespaces, z.searchURLIdx, urlIdxAt, func(_ uint32, a *Article, err error) bool {
+			return yield(a, err)
+		})
+	}
+}
+
+// EntriesByTitle returns an iterator over the entries in title order,
+// restricted to namespaces if any, deleted entries are skipped
+// iteration stops after yielding an error
+func (z *ZimReader) EntriesByTitle(ctx context.Context, namespaces ...byte) iter.Seq2[*Article, error] {
+	return func(yield func(*Article, error) bool) {
+		z.walkIndex(ctx, namespaces, z.searchTitleIdx, z.titleIdxAt, func(_ uint32, a *Article, err error) bool {
+			return yield(a, err)
+		})
+	}
+}
+
+// clusterEntry locates the blob of the content entry at URL index idx
+type clusterEntry struct {
+	cluster, blob, idx uint32
+}
+
+// clusterEntries returns the content entries of namespaces sorted by cluster
+// then blob
+func (z *ZimReader) clusterEntries(ctx context.Context, namespaces []byte) ([]clusterEntry, error) {
+	var entries []clusterEntry
+	var werr error
+	z.walkIndex(ctx, namespaces, z.searchURLIdx, urlIdxAt, func(idx uint32, a *Article, err error) bool {
+		if err != nil {
+			werr = err
+			return false
+		}
+		if a.Kind() == ContentKind {
+			entries = append(entries, clusterEntry{cluster: a.cluster, blob: a.blob, idx: idx})
+		}
+		return true
+	})
+	if werr != nil {
+		return nil, werr
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].cluster != entries[j].cluster {
+			return entries[i].cluster < entries[j].cluster
+		}
+		return entries[i].blob < entries[j].blob
+	})
+	return entries, nil
+}
+
+// EntriesByCluster returns an iterator over the content entries, restricted
+// to namespaces if any, in the order of their blobs in the file
+// reading entries in this order decompresses every cluster only once, the
+// entries are listed and sorted before the first one is yielded
+func (z *ZimReader) EntriesByCluster(ctx context.Context, namespaces ...byte) iter.Seq2[*Article, error] {
+	return func(yield func(*Article, error) bool) {
+		entries, err := z.clusterEntries(ctx, namespaces)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for _, e := range entries {
+			if err := ctx.Err(); err != nil {
+				yield(nil, err)
+				return
+			}
+			a, err := z.ArticleAtURLIdx(e.idx)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if !yield(a, nil) {
+				return
+			}
+		}
+	}
+}
+
+// WalkClusters calls fn with the data of every content entry of namespaces,
+// all the entries when namespaces is empty, in cluster order
+// every cluster is decompressed once without going through the clusters
+// cache, data is only valid during the call
+// the walk stops at the first error returned by fn
+func (z *ZimReader) WalkClusters(ctx context.Context, namespaces []byte, fn func(idx uint32, a *Article, data []byte) error) error {
+	entries, err := z.clusterEntries(ctx, namespaces)
+	if err != nil {
+		return err
+	}
+
+	for _, group := range groupByCluster(entries) {
+		if err := z.walkCluster(ctx, group, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// groupByCluster splits the sorted entries by cluster
+func groupByCluster(entries []clusterEntry) [][]clusterEntry {
+	var groups [][]clusterEntry
+	for i := 0; i < len(entries); {
+		j := i + 1
+		for j < len(entries) && entries[j].cluster == entries[i].cluster {
+			j++
+		}
+		groups = append(groups, entries[i:j])
+		i = j
+	}
+	return groups
+}
+
+// walkCluster calls fn for the entries of a single cluster
+func (z *ZimReader) walkCluster(ctx context.Context, entries []clusterEntry, fn func(idx uint32, a *Article, data []byte) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cluster, extended, err := z.clusterData(entries[0].cluster)
+	if err != nil {
+		return err
+	}
+	return z.walkBlobs(ctx, entries, cluster, extended, fn)
+}
+
+// walkBlobs calls fn for the entries of the uncompressed cluster
+func (z *ZimReader) walkBlobs(ctx context.Context, entries []clusterEntry, cluster []byte, extended bool, fn func(idx uint32, a *Article, data []byte) error) error {
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bs, be, err := blobBoundsIn(cluster, uint64(e.blob), extended)
+		if err != nil {
+			return err
+		}
+
+		a, err := z.ArticleAtURLIdx(e.idx)
+		if err != nil {
+			return err
+		}
+		if err := fn(e.idx, a, cluster[bs:be]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterData returns the content of a cluster after its information byte,
+// decompressed, starting with the blob offsets table
+func (z *ZimReader) clusterData(cluster uint32) ([]byte, bool, error) {
+	start, end, err := z.clusterOffsetsAtIdx(cluster)
+	if err != nil {
+		return nil, false, err
+	}
+	s, err := z.bytesRangeAt(start, start+1)
+	if err != nil {
+		return nil, false, err
+	}
+	compression, extended := clusterInfo(s[0])
+
+	switch compression {
+	case 0, 1:
+		b, err := z.bytesRangeAt(start+1, end+1)
+		return b, extended, err
+
+	case 4, 5:
+		if b, ok := z.bcache.Get(cluster); ok {
+			return b, extended, nil
+		}
+		b, err := z.decompressCluster(compression, start, end)
+		return b, extended, err
+	}
+
+	return nil, false, fmt.Errorf("%w %d", ErrUnsupportedCompression, compression)
+}
@@ -0,0 +1,55 @@
+package zim
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors returned by the reader, wrapped with details, test them with errors.Is
+var (
+	// ErrNotFound is returned when looking up a missing or deleted entry
+	ErrNotFound = errors.New("not found")
+
+	// ErrNotZim is returned when opening a file which is not a ZIM file
+	ErrNotZim = errors.New("not a ZIM file")
+
+	// ErrUnsupportedVersion is returned when opening a ZIM file with a major
+	// version other than 5 or 6
+	ErrUnsupportedVersion = errors.New("unsupported ZIM version")
+
+	// ErrUnsupportedCompression is returned when reading a cluster compressed
+	// with an unknown algorithm
+	ErrUnsupportedCompression = errors.New("unsupported compression")
+
+	// ErrCorrupt is returned when the file content is inconsistent, like
+	// offsets pointing outside of the file or an invalid compressed cluster
+	ErrCorrupt = errors.New("corrupt ZIM file")
+
+	// ErrClusterTooLarge is returned when a cluster is bigger than the
+	// WithMaxClusterSize limit
+	ErrClusterTooLarge = errors.New("cluster too large")
+
+	// ErrNoData is returned when opening the data of a redirect, link target
+	// or deleted entry
+	ErrNoData = errors.New("entry has no data")
+
+	// ErrNotRedirect is returned when asking the redirect index of an entry
+	// which is not a redirect
+	ErrNotRedirect = errors.New("not a redirect entry")
+
+	// ErrNoChecksum is returned when verifying a file without checksum
+	ErrNoChecksum = errors.New("no checksum in this ZIM file")
+
+	// ErrChecksumMismatch is returned when the file content does not match
+	// its stored checksum
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+
+	// ErrTooManyRedirects is returned by Resolve when a redirect chain is
+	// longer than MaxRedirectHops
+	ErrTooManyRedirects = errors.New("too many redirects")
+)
+
+// corruptf returns an ErrCorrupt error detailed by format
+func corruptf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrCorrupt}, args...)...)
+}
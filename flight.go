@@ -0,0 +1,63 @@
+package zim
+
+import (
+	"fmt"
+	"sync"
+)
+
+// flightCall is a call in progress, waiters block on done
+type flightCall struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+// flightGroup deduplicates concurrent calls for the same key, the callers
+// arriving while a call is in flight wait for its result
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[uint32]*flightCall
+
+	// joined is called when a caller joins a call in flight, a test hook
+	joined func(key uint32)
+}
+
+// Do calls fn once for all the concurrent callers of key
+// when fn panics the waiters get an error and the panic is propagated to the
+// caller running fn
+func (g *flightGroup) Do(key uint32, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if c, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		if g.joined != nil {
+			g.joined(key)
+		}
+		<-c.done
+		return c.data, c.err
+	}
+	if g.calls == nil {
+		g.calls = make(map[uint32]*flightCall)
+	}
+	c := &flightCall{done: make(chan struct{})}
+	g.calls[key] = c
+	g.mu.Unlock()
+
+	defer func() {
+		r := recover()
+		if r != nil {
+			c.data, c.err = nil, fmt.Errorf("call for %d panicked: %v", key, r)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+
+		if r != nil {
+			panic(r)
+		}
+	}()
+
+	c.data, c.err = fn()
+	return c.data, c.err
+}
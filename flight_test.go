@@ -0,0 +1,84 @@
+package zim
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFlightGroup(t *testing.T) {
+	const callers = 10
+	var calls int32
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	var joined int32
+	allJoined := make(chan struct{})
+	g := flightGroup{joined: func(uint32) {
+		if atomic.AddInt32(&joined, 1) == callers-1 {
+			close(allJoined)
+		}
+	}}
+
+	fn := func() ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return []byte("cluster"), nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, callers)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0], _ = g.Do(1, fn)
+	}()
+	<-started
+
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = g.Do(1, fn)
+		}(i)
+	}
+
+	// wait for the callers to join the call in flight
+	<-allJoined
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("expected 1 call got %d", calls)
+	}
+	for i, r := range results {
+		if string(r) != "cluster" {
+			t.Errorf("unexpected result %d %q", i, r)
+		}
+	}
+
+	// errors are returned to every caller and not kept
+	if _, err := g.Do(1, func() ([]byte, error) { return nil, errors.New("failed") }); err == nil {
+		t.Error("expected an error")
+	}
+	if b, err := g.Do(1, fn); err != nil || string(b) != "cluster" {
+		t.Errorf("unexpected result %q %v", b, err)
+	}
+
+	// a panic is propagated and releases the key
+	func() {
+		defer func() {
+			if r := recover(); r != "decoder" {
+				t.Errorf("expected the panic to be propagated got %v", r)
+			}
+		}()
+		g.Do(1, func() ([]byte, error) { panic("decoder") })
+	}()
+	if b, err := g.Do(1, fn); err != nil || string(b) != "cluster" {
+		t.Errorf("unexpected result after a panic %q %v", b, err)
+	}
+}
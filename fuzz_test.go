@@ -0,0 +1,189 @@
+package zim
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+// fuzzEntries are the entries of the files mutated by the fuzz targets
+var fuzzEntries = []testEntry{
+	{ns: 'A', url: "a.html", title: "A", mime: "text/html", data: []byte("<html>a</html>")},
+	{ns: 'A', url: "b.html", title: "B", mime: "text/plain", data: bytes.Repeat([]byte("b"), 100)},
+	{ns: 'A', url: "c.html", redirect: "A/a.html"},
+	{ns: 'I', url: "d.png", mime: "image/png", data: []byte{0x89, 'P', 'N', 'G'}},
+}
+
+// openFuzz opens the file content b, reading the file must fail with an error
+// rather than a panic
+func openFuzz(b []byte) (*ZimReader, error) {
+	return NewReaderFromReaderAt(bytes.NewReader(b), int64(len(b)), WithMaxClusterSize(1<<20))
+}
+
+// readAll reads every entry of z
+func readAll(t *testing.T, z *ZimReader) {
+	for idx := uint32(0); idx < z.ArticleCount && idx < 64; idx++ {
+		a, err := z.ArticleAtURLIdx(idx)
+		if err != nil {
+			continue
+		}
+		readArticle(t, z, a)
+	}
+	for _, err := range z.EntriesByTitle(context.Background()) {
+		if err != nil {
+			break
+		}
+	}
+	z.WalkClusters(context.Background(), nil, func(uint32, *Article, []byte) error {
+		return nil
+	})
+}
+
+// readArticle reads the data of a through all the code paths
+func readArticle(t *testing.T, z *ZimReader, a *Article) {
+	if a.Kind() == ContentKind {
+		a.MimeType()
+	}
+	z.Resolve(a)
+
+	data, err := a.Data()
+	if err == nil {
+		// the cluster is cached on the second read
+		if again, err := a.Data(); err == nil && !bytes.Equal(data, again) {
+			t.Errorf("data differs on the second read %q %q", data, again)
+		}
+	} else if !isFuzzError(err) {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	r, err := a.Open()
+	if err != nil {
+		if !isFuzzError(err) {
+			t.Errorf("unexpected error %v", err)
+		}
+		return
+	}
+	defer r.Close()
+	if _, err := io.Copy(io.Discard, io.LimitReader(r, 1<<20)); err != nil && !isFuzzError(err) {
+		t.Errorf("unexpected stream error %v", err)
+	}
+}
+
+// isFuzzError returns true for the errors expected on corrupt files
+func isFuzzError(err error) bool {
+	return errors.Is(err, ErrCorrupt) || errors.Is(err, ErrNotFound) ||
+		errors.Is(err, ErrNoData) || errors.Is(err, ErrUnsupportedCompression) ||
+		errors.Is(err, ErrClusterTooLarge)
+}
+
+func FuzzHeader(f *testing.F) {
+	tz := &testZim{major: 6, compression: 5, entries: fuzzEntries}
+	b := tz.bytes(f)
+	f.Add(b)
+	f.Add(b[:headerSize])
+	f.Add(b[:len(b)/2])
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		h, err := parseHeader(b)
+		if err != nil {
+			return
+		}
+		if err := h.validate(uint64(len(b))); err != nil {
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("expected ErrCorrupt got %v", err)
+			}
+			return
+		}
+
+		z, err := openFuzz(b)
+		if err != nil {
+			return
+		}
+		defer z.Close()
+		readAll(t, z)
+	})
+}
+
+func FuzzDirent(f *testing.F) {
+	tz := &testZim{major: 6, compression: 1, entries: fuzzEntries}
+	base := tz.bytes(f)
+	urlPtrPos := binary.LittleEndian.Uint64(base[32:40])
+
+	// seed with the entries of the file
+	z, err := openFuzz(base)
+	if err != nil {
+		f.Fatal(err)
+	}
+	for idx := uint32(0); idx < z.ArticleCount; idx++ {
+		start, err := z.OffsetAtURLIdx(idx)
+		if err != nil {
+			f.Fatal(err)
+		}
+		end := uint64(len(base))
+		if idx+1 < z.ArticleCount {
+			if end, err = z.OffsetAtURLIdx(idx + 1); err != nil {
+				f.Fatal(err)
+			}
+		}
+		f.Add(base[start:min(end, start+64)])
+	}
+	z.Close()
+
+	f.Fuzz(func(t *testing.T, dirent []byte) {
+		// the first url pointer points to the dirent appended to the file
+		b := append(bytes.Clone(base), dirent...)
+		binary.LittleEndian.PutUint64(b[urlPtrPos:], uint64(len(base)))
+
+		z, err := openFuzz(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer z.Close()
+
+		a, err := z.ArticleAtURLIdx(0)
+		if err != nil {
+			if !errors.Is(err, ErrCorrupt) {
+				t.Errorf("expected ErrCorrupt got %v", err)
+			}
+			return
+		}
+		readArticle(t, z, a)
+	})
+}
+
+func FuzzCluster(f *testing.F) {
+	tz := &testZim{major: 6, compression: 5, entries: fuzzEntries}
+	var blobs [][]byte
+	for _, e := range tz.sortedEntries() {
+		if e.hasData() {
+			blobs = append(blobs, e.data)
+		}
+	}
+	for _, c := range []struct {
+		compression uint8
+		extended    bool
+	}{{1, false}, {1, true}, {4, false}, {5, false}, {5, true}} {
+		tz := &testZim{major: 6, compression: c.compression, extended: c.extended}
+		f.Add(tz.cluster(f, blobs))
+	}
+
+	// the single cluster is replaced by the fuzzed one, the file having no
+	// checksum it ends at the end of the file
+	base := tz.bytes(f)
+	clusterPos := binary.LittleEndian.Uint64(base[binary.LittleEndian.Uint64(base[48:56]):])
+	base = bytes.Clone(base[:clusterPos])
+	binary.LittleEndian.PutUint64(base[72:80], 0)
+
+	f.Fuzz(func(t *testing.T, cluster []byte) {
+		b := append(bytes.Clone(base), cluster...)
+		z, err := openFuzz(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer z.Close()
+		readAll(t, z)
+	})
+}
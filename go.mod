@@ -1,6 +1,6 @@
 module github.com/akhenakh/gozim
 
-go 1.17
+go 1.23
 
 require (
 	github.com/GeertJohan/go.rice v1.0.2
@@ -0,0 +1,128 @@
+package zim
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	// headerSize is the size of the ZIM header including the checksum position
+	headerSize = 80
+
+	// noPage is used for the main and layout pages when not present
+	noPage = 0xffffffff
+)
+
+// UUID is the unique identifier of a ZIM file
+type UUID [16]byte
+
+func (u UUID) String() string {
+	b := hex.EncodeToString(u[:])
+	return b[0:8] + "-" + b[8:12] + "-" + b[12:16] + "-" + b[16:20] + "-" + b[20:32]
+}
+
+// Header is the ZIM file header
+// see https://wiki.openzim.org/wiki/ZIM_file_format#Header
+type Header struct {
+	MagicNumber  uint32
+	MajorVersion uint16
+	MinorVersion uint16
+	UUID         UUID
+	ArticleCount uint32
+	ClusterCount uint32
+	// positions of the URL, title and cluster pointer lists
+	URLPtrPos     uint64
+	TitlePtrPos   uint64
+	ClusterPtrPos uint64
+	MimeListPos   uint64
+	// MainPage and LayoutPage are URL indexes, 0xffffffff when not present
+	MainPage   uint32
+	LayoutPage uint32
+	// ChecksumPos is the position of the MD5 checksum, 0 for old files
+	// without checksum
+	ChecksumPos uint64
+}
+
+// HasMainPage returns true if the header points to a main page
+func (h Header) HasMainPage() bool {
+	return h.MainPage != noPage
+}
+
+// HasLayoutPage returns true if the header points to a layout page
+func (h Header) HasLayoutPage() bool {
+	return h.LayoutPage != noPage
+}
+
+// HasChecksum returns true if the file stores a checksum
+func (h Header) HasChecksum() bool {
+	return h.ChecksumPos != 0
+}
+
+// validate checks that the tables pointed by the header fit in a file of
+// size bytes
+func (h Header) validate(size uint64) error {
+	if !fits(h.URLPtrPos, uint64(h.ArticleCount)*8, size) {
+		return corruptf("url pointers at 0x%x past the end of the file", h.URLPtrPos)
+	}
+	if !fits(h.TitlePtrPos, uint64(h.ArticleCount)*4, size) {
+		return corruptf("title pointers at 0x%x past the end of the file", h.TitlePtrPos)
+	}
+	if !fits(h.ClusterPtrPos, uint64(h.ClusterCount)*8, size) {
+		return corruptf("cluster pointers at 0x%x past the end of the file", h.ClusterPtrPos)
+	}
+	if h.MimeListPos >= size {
+		return corruptf("invalid mime types list position 0x%x", h.MimeListPos)
+	}
+	if h.HasChecksum() && (h.ChecksumPos < headerSize || !fits(h.ChecksumPos, md5.Size, size)) {
+		return corruptf("invalid checksum position 0x%x", h.ChecksumPos)
+	}
+	return nil
+}
+
+// fits returns true if length bytes starting at pos fit in size bytes
+func fits(pos, length, size uint64) bool {
+	return pos <= size && length <= size-pos
+}
+
+// parseHeader decodes a ZIM header from its first 80 bytes
+func parseHeader(b []byte) (Header, error) {
+	var h Header
+	if len(b) < headerSize {
+		return h, ErrNotZim
+	}
+
+	le := binary.LittleEndian
+
+	// checking for file type
+	h.MagicNumber = le.Uint32(b[0:4])
+	if h.MagicNumber != zimHeader {
+		return h, ErrNotZim
+	}
+
+	// checking for version, major and minor are stored as 2 uint16
+	h.MajorVersion = le.Uint16(b[4:6])
+	if h.MajorVersion != 5 && h.MajorVersion != 6 {
+		return h, fmt.Errorf("%w %d, 5 and 6 only", ErrUnsupportedVersion, h.MajorVersion)
+	}
+	h.MinorVersion = le.Uint16(b[6:8])
+
+	copy(h.UUID[:], b[8:24])
+	h.ArticleCount = le.Uint32(b[24:28])
+	h.ClusterCount = le.Uint32(b[28:32])
+	h.URLPtrPos = le.Uint64(b[32:40])
+	h.TitlePtrPos = le.Uint64(b[40:48])
+	h.ClusterPtrPos = le.Uint64(b[48:56])
+	h.MimeListPos = le.Uint64(b[56:64])
+	h.MainPage = le.Uint32(b[64:68])
+	h.LayoutPage = le.Uint32(b[68:72])
+
+	// files with a mime list starting before 80 have a shorter header
+	// without checksum
+	if h.MimeListPos >= headerSize {
+		h.ChecksumPos = le.Uint64(b[72:80])
+	}
+
+	return h, nil
+}
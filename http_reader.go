@@ -0,0 +1,314 @@
+package zim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHTTPBlockSize is the default size of the blocks fetched and
+	// cached by HTTPReaderAt
+	DefaultHTTPBlockSize = 64 << 10
+
+	// DefaultHTTPCacheBlocks is the default number of blocks kept in cache by
+	// HTTPReaderAt, 16M with the default block size
+	DefaultHTTPCacheBlocks = 256
+
+	// DefaultHTTPTimeout is the timeout of the requests made by the default
+	// client of HTTPReaderAt
+	DefaultHTTPTimeout = 30 * time.Second
+)
+
+// HTTPOption configures an HTTPReaderAt
+type HTTPOption func(*httpOptions) error
+
+type httpOptions struct {
+	client      *http.Client
+	blockSize   int64
+	cacheBlocks int
+}
+
+// WithHTTPClient sets the client used to query the server, default to a
+// client with a DefaultHTTPTimeout timeout
+// a client without timeout blocks the readers of a stalled range forever
+func WithHTTPClient(c *http.Client) HTTPOption {
+	return func(o *httpOptions) error {
+		if c == nil {
+			return errors.New("http client can't be nil")
+		}
+		o.client = c
+		return nil
+	}
+}
+
+// WithHTTPBlockSize sets the size of the blocks fetched from the server,
+// every read is rounded up to whole blocks
+func WithHTTPBlockSize(n int) HTTPOption {
+	return func(o *httpOptions) error {
+		if n <= 0 {
+			return errors.New("http block size must be positive")
+		}
+		o.blockSize = int64(n)
+		return nil
+	}
+}
+
+// WithHTTPCacheBlocks sets the number of blocks kept in cache, 0 disables
+// the cache
+func WithHTTPCacheBlocks(n int) HTTPOption {
+	return func(o *httpOptions) error {
+		if n < 0 {
+			return errors.New("http cache blocks must be positive")
+		}
+		o.cacheBlocks = n
+		return nil
+	}
+}
+
+// HTTPReaderAt is an io.ReaderAt reading a remote file using HTTP range
+// requests, to be used with NewReaderFromReaderAt
+// the file is read by blocks, recent blocks are cached and concurrent reads
+// of the same blocks share a single request
+type HTTPReaderAt struct {
+	url  string
+	size int64
+	opts httpOptions
+
+	// blocks are indexed by their position in the file
+	cache *clusterCache
+
+	// blocks being fetched, a single range request may fetch several blocks
+	mu       sync.Mutex
+	inflight map[uint32]*flightCall
+}
+
+// NewHTTPReaderAt returns a reader for the file at url, the server must
+// support range requests
+func NewHTTPReaderAt(url string, opts ...HTTPOption) (*HTTPReaderAt, error) {
+	o := httpOptions{
+		client:      &http.Client{Timeout: DefaultHTTPTimeout},
+		blockSize:   DefaultHTTPBlockSize,
+		cacheBlocks: DefaultHTTPCacheBlocks,
+	}
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &HTTPReaderAt{
+		url:      url,
+		opts:     o,
+		cache:    newClusterCache(o.cacheBlocks, 0),
+		inflight: make(map[uint32]*flightCall),
+	}
+
+	// the size is returned in the Content-Range of any partial response
+	resp, err := h.get(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	_, _, h.size, err = parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return nil, err
+	}
+	if h.size/o.blockSize >= 1<<32 {
+		return nil, errors.New("http block size is too small for this file")
+	}
+	return h, nil
+}
+
+// Size returns the size of the remote file
+func (h *HTTPReaderAt) Size() int64 {
+	return h.size
+}
+
+// ReadAt implements io.ReaderAt
+func (h *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if off >= h.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > h.size {
+		end = h.size
+	}
+	if end == off {
+		return 0, nil
+	}
+
+	first := uint32(off / h.opts.blockSize)
+	last := uint32((end - 1) / h.opts.blockSize)
+
+	// look for the blocks in cache then in flight, fetching the others
+	blocks := make([][]byte, last-first+1)
+	calls := make([]*flightCall, len(blocks))
+	var missing []uint32
+
+	h.mu.Lock()
+	for b := first; b <= last; b++ {
+		if data, ok := h.cache.Get(b); ok {
+			blocks[b-first] = data
+			continue
+		}
+		c, ok := h.inflight[b]
+		if !ok {
+			c = &flightCall{done: make(chan struct{})}
+			h.inflight[b] = c
+			missing = append(missing, b)
+		}
+		calls[b-first] = c
+	}
+	h.mu.Unlock()
+
+	// contiguous missing blocks are fetched with a single request
+	for i := 0; i < len(missing); {
+		j := i
+		for j+1 < len(missing) && missing[j+1] == missing[j]+1 {
+			j++
+		}
+		h.fetchBlocks(missing[i], missing[j])
+		i = j + 1
+	}
+
+	var err error
+	for i, c := range calls {
+		if c == nil {
+			continue
+		}
+		<-c.done
+		if c.err != nil && err == nil {
+			err = c.err
+		}
+		blocks[i] = c.data
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for i, data := range blocks {
+		start := int64(first+uint32(i)) * h.opts.blockSize
+		if i == 0 {
+			data = data[off-start:]
+		}
+		n += copy(p[n:], data)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fetchBlocks fetches the blocks from first to last included, caches them
+// and completes their in flight calls
+func (h *HTTPReaderAt) fetchBlocks(first, last uint32) {
+	start := int64(first) * h.opts.blockSize
+	end := int64(last+1) * h.opts.blockSize
+	if end > h.size {
+		end = h.size
+	}
+
+	buf := make([]byte, end-start)
+	err := h.readRange(buf, start)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for b := first; b <= last; b++ {
+		c := h.inflight[b]
+		delete(h.inflight, b)
+		if err != nil {
+			c.err = err
+			close(c.done)
+			continue
+		}
+
+		bs := int64(b-first) * h.opts.blockSize
+		be := bs + h.opts.blockSize
+		if be > int64(len(buf)) {
+			be = int64(len(buf))
+		}
+		// copy the block so the cache does not retain the whole range
+		c.data = make([]byte, be-bs)
+		copy(c.data, buf[bs:be])
+		h.cache.Add(b, c.data)
+		close(c.done)
+	}
+}
+
+// readRange fills buf with the bytes starting at off
+func (h *HTTPReaderAt) readRange(buf []byte, off int64) error {
+	resp, err := h.get(off, off+int64(len(buf))-1)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.ReadFull(resp.Body, buf); err != nil {
+		return fmt.Errorf("can't read range at %d %w", off, err)
+	}
+	return nil
+}
+
+// get requests the bytes from start to end included
+func (h *HTTPReaderAt) get(start, end int64) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := h.opts.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("range request not supported, got status %s", resp.Status)
+	}
+
+	// a server or a proxy may answer with another range
+	rs, re, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err == nil && (rs != start || re != end) {
+		err = fmt.Errorf("requested range %d-%d got %d-%d", start, end, rs, re)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// parseContentRange returns the range and the complete length from a
+// Content-Range header like "bytes 0-0/1234"
+func parseContentRange(s string) (start, end, size int64, err error) {
+	rng, length, ok := strings.Cut(strings.TrimPrefix(s, "bytes "), "/")
+	first, last, ok2 := strings.Cut(rng, "-")
+	if !strings.HasPrefix(s, "bytes ") || !ok || !ok2 {
+		return 0, 0, 0, fmt.Errorf("invalid Content-Range %q", s)
+	}
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err == nil {
+		end, err = strconv.ParseInt(last, 10, 64)
+	}
+	if err != nil || start < 0 || end < start {
+		return 0, 0, 0, fmt.Errorf("invalid range in Content-Range %q", s)
+	}
+	size, err = strconv.ParseInt(length, 10, 64)
+	if err != nil || size <= 0 || end >= size {
+		return 0, 0, 0, fmt.Errorf("unknown file size in Content-Range %q", s)
+	}
+	return start, end, size, nil
+}
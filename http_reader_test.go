@@ -0,0 +1,169 @@
+package zim
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serve test.zim counting the requests
+func newZimServer(t *testing.T) (*httptest.Server, []byte, *int32) {
+	b, err := os.ReadFile("test.zim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.ServeContent(w, r, "test.zim", time.Time{}, bytes.NewReader(b))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, b, &requests
+}
+
+func TestHTTPReaderAt(t *testing.T) {
+	ts, b, _ := newZimServer(t)
+
+	h, err := NewHTTPReaderAt(ts.URL, WithHTTPBlockSize(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Size() != int64(len(b)) {
+		t.Fatalf("expected size %d got %d", len(b), h.Size())
+	}
+
+	z, err := NewReaderFromReaderAt(h, h.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	for idx := uint32(0); idx < z.ArticleCount; idx++ {
+		a, err := z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Kind() != ContentKind {
+			continue
+		}
+		expected, err := Z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := a.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedData, err := expected.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expectedData) {
+			t.Errorf("unexpected data for %s", a.FullURL())
+		}
+	}
+
+	// the end of the file is returned with io.EOF
+	p := make([]byte, 100)
+	n, err := h.ReadAt(p, int64(len(b)-10))
+	if n != 10 || err == nil {
+		t.Errorf("expected 10 bytes and EOF got %d %v", n, err)
+	}
+	if !bytes.Equal(p[:n], b[len(b)-10:]) {
+		t.Error("unexpected bytes at the end of the file")
+	}
+}
+
+func TestHTTPReaderAtCoalescing(t *testing.T) {
+	ts, b, requests := newZimServer(t)
+
+	h, err := NewHTTPReaderAt(ts.URL, WithHTTPBlockSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(requests, 0)
+
+	// contiguous blocks are fetched at once
+	p := make([]byte, 10*1024)
+	if _, err := h.ReadAt(p, 100); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p, b[100:100+len(p)]) {
+		t.Error("unexpected bytes")
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected 1 request got %d", n)
+	}
+
+	// cached blocks are not fetched again
+	if _, err := h.ReadAt(p[:1024], 2048); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected 1 request got %d", n)
+	}
+
+	// concurrent reads of the same block share the request
+	atomic.StoreInt32(requests, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p := make([]byte, 512)
+			if _, err := h.ReadAt(p, 20*1024); err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(p, b[20*1024:20*1024+512]) {
+				t.Error("unexpected bytes")
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("expected 1 request got %d", n)
+	}
+}
+
+func TestHTTPReaderAtNoRange(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("no range support"))
+	}))
+	defer ts.Close()
+
+	if _, err := NewHTTPReaderAt(ts.URL); err == nil {
+		t.Error("a server without range support should be rejected")
+	}
+}
+
+func TestHTTPReaderAtWrongRange(t *testing.T) {
+	ts, b, _ := newZimServer(t)
+	h, err := NewHTTPReaderAt(ts.URL, WithHTTPBlockSize(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a proxy ignoring the requested range start
+	wrong := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Set("Range", "bytes=0-4095")
+		http.ServeContent(w, r, "test.zim", time.Time{}, bytes.NewReader(b))
+	}))
+	defer wrong.Close()
+	h.url = wrong.URL
+
+	p := make([]byte, 100)
+	if _, err := h.ReadAt(p, 8192); err == nil {
+		t.Error("a response with another range should be rejected")
+	}
+	if _, ok := h.cache.Get(2); ok {
+		t.Error("the block of a wrong range should not be cached")
+	}
+}
@@ -0,0 +1,96 @@
+package zim
+
+// MetadataNamespace is the namespace storing the ZIM metadata, for both old
+// and new namespace schemes
+const MetadataNamespace = 'M'
+
+// illustrationKey is the metadata key for the 48x48 PNG illustration
+const illustrationKey = "Illustration_48x48@1"
+
+// favicons urls used as illustration by files predating the Illustration metadata
+var faviconURLs = []string{"-/favicon", "I/favicon.png"}
+
+// Metadata holds the ZIM file metadata
+// see https://wiki.openzim.org/wiki/Metadata
+type Metadata struct {
+	Title           string
+	Description     string
+	LongDescription string
+	Language        string
+	Creator         string
+	Publisher       string
+	Date            string
+	Name            string
+	Flavour         string
+	Tags            string
+	// Illustration is a 48x48 PNG image, or the favicon for older files
+	Illustration []byte
+	// Raw holds every metadata entry by key, including unknown ones
+	Raw map[string]string
+}
+
+// Metadata reads all the entries in the metadata namespace
+func (z *ZimReader) Metadata() (*Metadata, error) {
+	start, end, err := z.NamespaceRange(MetadataNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metadata{Raw: make(map[string]string, end-start)}
+	for idx := start; idx < end; idx++ {
+		a, err := z.ArticleAtURLIdx(idx)
+		if err != nil {
+			return nil, err
+		}
+		if a.Kind() != ContentKind {
+			continue
+		}
+
+		b, err := a.Data()
+		if err != nil {
+			return nil, err
+		}
+		m.Raw[a.url] = string(b)
+	}
+
+	m.Title = m.Raw["Title"]
+	m.Description = m.Raw["Description"]
+	m.LongDescription = m.Raw["LongDescription"]
+	m.Language = m.Raw["Language"]
+	m.Creator = m.Raw["Creator"]
+	m.Publisher = m.Raw["Publisher"]
+	m.Date = m.Raw["Date"]
+	m.Name = m.Raw["Name"]
+	m.Flavour = m.Raw["Flavour"]
+	m.Tags = m.Raw["Tags"]
+
+	if ill, ok := m.Raw[illustrationKey]; ok {
+		m.Illustration = []byte(ill)
+	} else {
+		m.Illustration = z.favicon()
+	}
+
+	return m, nil
+}
+
+// favicon returns the favicon used by older files, nil if not found
+func (z *ZimReader) favicon() []byte {
+	for _, url := range faviconURLs {
+		a, err := z.GetPageNoIndex(url)
+		if err != nil {
+			continue
+		}
+
+		a, err = z.Resolve(a)
+		if err != nil {
+			continue
+		}
+
+		b, err := a.Data()
+		if err != nil || len(b) == 0 {
+			continue
+		}
+		return b
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package zim
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// rangeSlicer is implemented by sources able to return a range of bytes
+// without copying, like a memory mapped file
+type rangeSlicer interface {
+	slice(start, end uint64) ([]byte, error)
+}
+
+// mappedRange returns the bytes from start to end of r when they are memory
+// mapped, it returns false when reading them would need a copy
+func mappedRange(r io.ReaderAt, start, end uint64) ([]byte, bool) {
+	switch r := r.(type) {
+	case *mmapFile:
+		b, err := r.slice(start, end)
+		return b, err == nil
+	case *splitFile:
+		return r.mapped(start, end)
+	}
+	return nil, false
+}
+
+// mmapFile is a memory mapped file
+type mmapFile struct {
+	f    *os.File
+	data []byte
+	size int64
+}
+
+// newMmapFile maps the first size bytes of f in memory
+func newMmapFile(f *os.File, size int64) (*mmapFile, error) {
+	// we need a multiple of page size bigger than the file
+	pc := size / int64(os.Getpagesize())
+	totalMmap := pc*int64(os.Getpagesize()) + int64(os.Getpagesize())
+	if (size % int64(os.Getpagesize())) == 0 {
+		totalMmap = size
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(totalMmap), syscall.PROT_READ, syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, fmt.Errorf("can't mmap %w", err)
+	}
+	return &mmapFile{f: f, data: data, size: size}, nil
+}
+
+func (m *mmapFile) slice(start, end uint64) ([]byte, error) {
+	if start > end || end > uint64(m.size) {
+		return nil, corruptf("can't read bytes 0x%x-0x%x", start, end)
+	}
+	return m.data[start:end], nil
+}
+
+func (m *mmapFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= m.size {
+		return 0, io.EOF
+	}
+	n := copy(p, m.data[off:m.size])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close unmaps then closes the file
+func (m *mmapFile) Close() error {
+	err := syscall.Munmap(m.data)
+	if cerr := m.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package zim
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+const (
+	// ArticleNamespace holds the articles in the old namespace scheme
+	ArticleNamespace = 'A'
+	// ContentNamespace holds all the user content in the new namespace scheme
+	ContentNamespace = 'C'
+	// IndexNamespace holds the listings and search indexes in the new
+	// namespace scheme
+	IndexNamespace = 'X'
+)
+
+// frontArticlesListingURL is the listing of the front articles ordered by title
+const frontArticlesListingURL = "X/listing/titleOrdered/v1"
+
+// frontArticles is the lazily loaded front articles listing
+type frontArticles struct {
+	once sync.Once
+	err  error
+	// listed is false when the file has no front articles listing
+	listed bool
+	// listing is the raw listing, URL indexes as uint32 ordered by title
+	listing []byte
+	// set is a bitset of the listed URL indexes
+	set []uint64
+	// without listing, front articles are the main namespace range
+	// [start, start+count) of the title index
+	start, count uint32
+}
+
+// HasNewNamespaceScheme returns true for files using the namespace scheme
+// introduced with ZIM 6.1, where all the user content is in the C namespace
+func (z *ZimReader) HasNewNamespaceScheme() bool {
+	return z.MajorVersion == 6 && z.MinorVersion >= 1
+}
+
+// MainNamespace returns the namespace holding the articles, C for the new
+// namespace scheme, A otherwise
+func (z *ZimReader) MainNamespace() byte {
+	if z.HasNewNamespaceScheme() {
+		return ContentNamespace
+	}
+	return ArticleNamespace
+}
+
+// NamespaceRange returns the URL indexes range [start, end) of the entries in
+// namespace ns
+func (z *ZimReader) NamespaceRange(ns byte) (start, end uint32, err error) {
+	start, err = z.searchURLIdx(func(a *Article) bool {
+		return a.Namespace >= ns
+	})
+	if err != nil {
+		return
+	}
+	end, err = z.searchURLIdx(func(a *Article) bool {
+		return a.Namespace > ns
+	})
+	return
+}
+
+// IsFrontArticle returns true if the entry at URL index idx is a front article,
+// a real page meant to be displayed to the user not a resource like CSS or images
+// new namespace files list their front articles, otherwise every html page of
+// the main namespace is a front article
+func (z *ZimReader) IsFrontArticle(idx uint32) (bool, error) {
+	if z.HasNewNamespaceScheme() {
+		fa := z.loadFrontArticles()
+		if fa.err != nil {
+			return false, fa.err
+		}
+		if fa.listed {
+			if idx >= z.ArticleCount {
+				return false, nil
+			}
+			return fa.set[idx/64]&(1<<(idx%64)) != 0, nil
+		}
+	}
+
+	a, err := z.ArticleAtURLIdx(idx)
+	if err != nil {
+		return false, err
+	}
+	if a.Namespace != z.MainNamespace() || a.Kind() == LinkTargetKind || a.Kind() == DeletedKind {
+		return false, nil
+	}
+	if a.Kind() == RedirectKind || !z.HasNewNamespaceScheme() {
+		return true, nil
+	}
+	return strings.HasPrefix(a.MimeType(), "text/html"), nil
+}
+
+// loadFrontArticles reads the front articles listing once
+func (z *ZimReader) loadFrontArticles() *frontArticles {
+	fa := &z.front
+	fa.once.Do(func() {
+		a, err := z.GetPageNoIndex(frontArticlesListingURL)
+		if err != nil {
+			// no listing in this file, fallback to the title index
+			fa.start, err = z.searchTitleIdx(titleAtLeast(z.MainNamespace(), ""))
+			if err != nil {
+				fa.err = err
+				return
+			}
+			end, err := z.searchTitleIdx(titleAtLeast(z.MainNamespace()+1, ""))
+			if err != nil {
+				fa.err = err
+				return
+			}
+			fa.count = end - fa.start
+			return
+		}
+
+		b, err := a.Data()
+		if err != nil {
+			fa.err = err
+			return
+		}
+
+		fa.listing = b
+		fa.set = make([]uint64, (z.ArticleCount+63)/64)
+		for i := 0; i+4 <= len(b); i += 4 {
+			idx, err := readInt32(b[i:i+4], nil)
+			if err != nil {
+				fa.err = err
+				return
+			}
+			if idx < z.ArticleCount {
+				fa.set[idx/64] |= 1 << (idx % 64)
+			}
+		}
+		fa.listed = true
+	})
+	return fa
+}
+
+// FrontArticleCount returns the number of front articles
+func (z *ZimReader) FrontArticleCount() (uint32, error) {
+	fa := z.loadFrontArticles()
+	if fa.err != nil {
+		return 0, fa.err
+	}
+	if fa.listed {
+		return uint32(len(fa.listing) / 4), nil
+	}
+	return fa.count, nil
+}
+
+// FrontArticleIdxAt returns the URL index of the front article at position pos,
+// front articles are ordered by title
+// it uses the X/listing/titleOrdered/v1 listing when present, the main
+// namespace part of the title index otherwise, which also contains resources
+// for new namespace files without listing
+func (z *ZimReader) FrontArticleIdxAt(pos uint32) (uint32, error) {
+	fa := z.loadFrontArticles()
+	if fa.err != nil {
+		return 0, fa.err
+	}
+	if fa.listed {
+		if uint64(pos)*4+4 > uint64(len(fa.listing)) {
+			return 0, fmt.Errorf("front article %d %w", pos, ErrNotFound)
+		}
+		idx, err := readInt32(fa.listing[pos*4:pos*4+4], nil)
+		if err != nil {
+			return 0, err
+		}
+		if idx >= z.ArticleCount {
+			return 0, corruptf("invalid url index %d in the front articles listing", idx)
+		}
+		return idx, nil
+	}
+	if pos >= fa.count {
+		return 0, fmt.Errorf("front article %d %w", pos, ErrNotFound)
+	}
+	return z.titleIdxAt(fa.start + pos)
+}
+
+// list all front articles URL indexes ordered by title, skipping CSS, images
+// and other resources
+func (z *ZimReader) ListFrontArticlesIterator(cb func(uint32)) error {
+	count, err := z.FrontArticleCount()
+	if err != nil {
+		return err
+	}
+	for pos := uint32(0); pos < count; pos++ {
+		idx, err := z.FrontArticleIdxAt(pos)
+		if err != nil {
+			return err
+		}
+		cb(idx)
+	}
+	return nil
+}
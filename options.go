@@ -0,0 +1,96 @@
+package zim
+
+import "errors"
+
+const (
+	// DefaultClusterCacheCount is the default number of uncompressed clusters
+	// kept in cache, around 1M per cluster
+	DefaultClusterCacheCount = 5
+)
+
+// Option configures a ZimReader
+type Option func(*options) error
+
+type options struct {
+	mmap bool
+	// clusters cache capacity, in clusters and in bytes, 0 bytes for no limit
+	cacheCount int
+	cacheBytes int64
+	// maximum size of a decompressed cluster, 0 for no limit
+	maxClusterSize int64
+	// concurrency of the zstd decoders, 0 for the decoder default
+	decoderConcurrency int
+}
+
+func defaultOptions() options {
+	return options{
+		cacheCount: DefaultClusterCacheCount,
+	}
+}
+
+// buildOptions applies opts over the default options
+func buildOptions(opts []Option) (options, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+// WithMmap maps the ZIM file in memory instead of reading it with pread
+func WithMmap(mmap bool) Option {
+	return func(o *options) error {
+		o.mmap = mmap
+		return nil
+	}
+}
+
+// WithClusterCacheCount sets the maximum number of uncompressed clusters kept
+// in cache, 0 disables the cache
+func WithClusterCacheCount(count int) Option {
+	return func(o *options) error {
+		if count < 0 {
+			return errors.New("cluster cache count must be positive")
+		}
+		o.cacheCount = count
+		return nil
+	}
+}
+
+// WithClusterCacheBytes sets the maximum size in bytes of the uncompressed
+// clusters kept in cache, 0 for no byte limit
+func WithClusterCacheBytes(n int64) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return errors.New("cluster cache bytes must be positive")
+		}
+		o.cacheBytes = n
+		return nil
+	}
+}
+
+// WithMaxClusterSize sets the maximum size of a decompressed cluster, reading
+// a bigger cluster fails, 0 for no limit
+func WithMaxClusterSize(n int64) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return errors.New("max cluster size must be positive")
+		}
+		o.maxClusterSize = n
+		return nil
+	}
+}
+
+// WithDecoderConcurrency sets the number of goroutines used to decode a zstd
+// cluster, 0 uses the decoder default of GOMAXPROCS
+func WithDecoderConcurrency(n int) Option {
+	return func(o *options) error {
+		if n < 0 {
+			return errors.New("decoder concurrency must be positive")
+		}
+		o.decoderConcurrency = n
+		return nil
+	}
+}
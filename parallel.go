@@ -0,0 +1,111 @@
+package zim
+
+import (
+	"context"
+	"runtime"
+	"sync"
+)
+
+// clusterJob is a cluster decompressed by a worker, done is closed when data
+// or err is set
+type clusterJob struct {
+	entries  []clusterEntry
+	data     []byte
+	extended bool
+	err      error
+	done     chan struct{}
+}
+
+// WalkClustersParallel is WalkClusters decompressing up to workers clusters
+// concurrently, GOMAXPROCS workers when workers <= 0
+// fn is still called from the calling goroutine in cluster order, the output
+// is the same as WalkClusters
+// clusters are decompressed in order while the decompressed clusters waiting
+// for fn use less than maxMemory bytes, 0 for no limit, each worker can
+// exceed the budget by one cluster
+func (z *ZimReader) WalkClustersParallel(ctx context.Context, namespaces []byte, workers int, maxMemory int64,
+	fn func(idx uint32, a *Article, data []byte) error) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	entries, err := z.clusterEntries(ctx, namespaces)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// memory held by the decompressed clusters not yet walked
+	var mu sync.Mutex
+	memCond := sync.NewCond(&mu)
+	var held int64
+	stop := context.AfterFunc(ctx, func() {
+		mu.Lock()
+		memCond.Broadcast()
+		mu.Unlock()
+	})
+	defer stop()
+
+	jobs := make(chan *clusterJob)
+	// the jobs in cluster order, bounding the clusters in flight
+	results := make(chan *clusterJob, workers)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for j := range jobs {
+				j.data, j.extended, j.err = z.clusterData(j.entries[0].cluster)
+				mu.Lock()
+				held += int64(len(j.data))
+				mu.Unlock()
+				close(j.done)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		defer close(results)
+
+		for _, group := range groupByCluster(entries) {
+			mu.Lock()
+			for maxMemory > 0 && held >= maxMemory && ctx.Err() == nil {
+				memCond.Wait()
+			}
+			mu.Unlock()
+
+			j := &clusterJob{entries: group, done: make(chan struct{})}
+			select {
+			case results <- j:
+			case <-ctx.Done():
+				return
+			}
+			jobs <- j
+		}
+	}()
+
+	// walk the clusters in order, waiting for every running job to finish
+	for j := range results {
+		<-j.done
+		if err == nil {
+			err = j.err
+			if err == nil {
+				err = z.walkBlobs(ctx, j.entries, j.data, j.extended, fn)
+			}
+			if err != nil {
+				cancel()
+			}
+		}
+
+		mu.Lock()
+		held -= int64(len(j.data))
+		memCond.Broadcast()
+		mu.Unlock()
+	}
+	if err == nil {
+		// the clusters left when the context was canceled
+		err = ctx.Err()
+	}
+	return err
+}
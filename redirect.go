@@ -0,0 +1,57 @@
+package zim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxRedirectHops is the maximum number of redirects followed by Resolve
+const MaxRedirectHops = 32
+
+// RedirectLoopError is returned by Resolve when redirects form a cycle
+type RedirectLoopError struct {
+	// Chain holds the urls of the followed redirects, ending with the url
+	// already visited
+	Chain []string
+}
+
+func (e *RedirectLoopError) Error() string {
+	return "redirect loop: " + strings.Join(e.Chain, " -> ")
+}
+
+// Resolve follows the redirect chain starting at article a and returns the
+// final entry, a itself if it's not a redirect
+func (z *ZimReader) Resolve(a *Article) (*Article, error) {
+	// entries are identified by their directory offset
+	visited := map[uint64]bool{a.URLPtr: true}
+	chain := []string{a.FullURL()}
+
+	for hops := 0; a.Kind() == RedirectKind; hops++ {
+		if hops >= MaxRedirectHops {
+			return nil, ErrTooManyRedirects
+		}
+
+		ridx, err := a.RedirectIndex()
+		if err != nil {
+			return nil, err
+		}
+		if ridx >= z.ArticleCount {
+			return nil, corruptf("invalid redirect index %d for %s", ridx, a.FullURL())
+		}
+		a, err = z.ArticleAtURLIdx(ridx)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, a.FullURL())
+		if visited[a.URLPtr] {
+			return nil, &RedirectLoopError{Chain: chain}
+		}
+		visited[a.URLPtr] = true
+	}
+
+	if a.Kind() == DeletedKind {
+		return nil, fmt.Errorf("redirect to a deleted entry %w", ErrNotFound)
+	}
+	return a, nil
+}
@@ -0,0 +1,184 @@
+package zim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// splitExt is the extension of the first part of a split ZIM file, the
+// following parts being .zimab, .zimac ... .zimzz
+const splitExt = ".zimaa"
+
+// splitPart is one of the files of a split ZIM file, starting at off
+type splitPart struct {
+	r    io.ReaderAt
+	c    io.Closer
+	off  int64
+	size int64
+}
+
+// splitFile presents the parts of a split ZIM file as one contiguous file
+type splitFile struct {
+	parts []splitPart
+	size  int64
+}
+
+// splitPaths returns the paths of the parts of the split ZIM file designated
+// by path, either the .zim name or the first .zimaa part
+// it returns nil if path is not a split ZIM file
+func splitPaths(path string) ([]string, error) {
+	var base string
+	switch {
+	case strings.HasSuffix(path, splitExt):
+		base = strings.TrimSuffix(path, "aa")
+	case strings.HasSuffix(path, ".zim"):
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		if _, err := os.Stat(path + "aa"); err != nil {
+			return nil, nil
+		}
+		base = path
+	default:
+		return nil, nil
+	}
+
+	var paths []string
+	for i := 0; i < 26*26; i++ {
+		p := base + string(rune('a'+i/26)) + string(rune('a'+i%26))
+		if _, err := os.Stat(p); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("can't find split zim parts for %s", path)
+	}
+	return paths, nil
+}
+
+// openSplitFile opens all the parts, mapping them in memory if mmap is true
+func openSplitFile(paths []string, mmap bool) (*splitFile, error) {
+	s := new(splitFile)
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			s.Close()
+			return nil, err
+		}
+		size := fi.Size()
+		if size == 0 {
+			f.Close()
+			s.Close()
+			return nil, fmt.Errorf("split zim part %s is empty", p)
+		}
+
+		part := splitPart{r: f, c: f, off: s.size, size: size}
+		if mmap {
+			m, err := newMmapFile(f, size)
+			if err != nil {
+				f.Close()
+				s.Close()
+				return nil, err
+			}
+			part.r, part.c = m, m
+		}
+		s.parts = append(s.parts, part)
+		s.size += size
+	}
+	return s, nil
+}
+
+// partAt returns the index of the part containing offset off
+func (s *splitFile) partAt(off int64) int {
+	return sort.Search(len(s.parts), func(i int) bool {
+		return s.parts[i].off+s.parts[i].size > off
+	})
+}
+
+// slice returns the range without copying when it's contained in a single
+// memory mapped part, it copies across parts
+func (s *splitFile) slice(start, end uint64) ([]byte, error) {
+	if start > end || end > uint64(s.size) {
+		return nil, corruptf("can't read bytes 0x%x-0x%x", start, end)
+	}
+
+	if b, ok := s.mapped(start, end); ok {
+		return b, nil
+	}
+
+	buf := make([]byte, end-start)
+	n, err := s.ReadAt(buf, int64(start))
+	if err != nil && !(err == io.EOF && n == len(buf)) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// mapped returns the range without copying when it's contained in a single
+// memory mapped part
+func (s *splitFile) mapped(start, end uint64) ([]byte, bool) {
+	i := s.partAt(int64(start))
+	if i >= len(s.parts) || int64(end) > s.parts[i].off+s.parts[i].size {
+		return nil, false
+	}
+	p := s.parts[i]
+	m, ok := p.r.(*mmapFile)
+	if !ok {
+		return nil, false
+	}
+	b, err := m.slice(start-uint64(p.off), end-uint64(p.off))
+	return b, err == nil
+}
+
+// ReadAt implements io.ReaderAt, reading across parts
+func (s *splitFile) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	n := 0
+	for i := s.partAt(off); i < len(s.parts) && n < len(p); i++ {
+		part := s.parts[i]
+		start := off + int64(n) - part.off
+		want := p[n:]
+		if rest := part.size - start; int64(len(want)) > rest {
+			want = want[:rest]
+		}
+
+		m, err := part.r.ReadAt(want, start)
+		n += m
+		if err != nil && !(err == io.EOF && m == len(want)) {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes all the parts
+func (s *splitFile) Close() error {
+	var err error
+	for _, p := range s.parts {
+		if cerr := p.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,167 @@
+package zim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Open returns a reader over the article data, contrary to Data it doesn't
+// need the whole blob in memory:
+// uncompressed blobs are read directly from the file, compressed blobs are
+// decompressed while reading unless their cluster is already in cache
+func (a *Article) Open() (io.ReadSeekCloser, error) {
+	if a.Kind() != ContentKind {
+		return nil, ErrNoData
+	}
+	start, end, compression, extended, err := a.clusterHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case 0, 1:
+		bs, be, err := a.z.uncompressedBlob(start, end, uint64(a.blob), extended)
+		if err != nil {
+			return nil, err
+		}
+		// memory mapped blobs are read without copy
+		if b, ok := mappedRange(a.z.r, bs, be); ok {
+			return nopCloser{bytes.NewReader(b)}, nil
+		}
+		return nopCloser{io.NewSectionReader(a.z.r, int64(bs), int64(be-bs))}, nil
+
+	case 4, 5:
+		if blob, ok := a.z.bcache.Get(a.cluster); ok {
+			bs, be, err := blobBoundsIn(blob, uint64(a.blob), extended)
+			if err != nil {
+				return nil, err
+			}
+			return nopCloser{bytes.NewReader(blob[bs:be])}, nil
+		}
+		return a.z.newBlobStream(compression, start, end, uint64(a.blob), extended)
+	}
+
+	return nil, fmt.Errorf("%w %d", ErrUnsupportedCompression, compression)
+}
+
+// nopCloser adds a no-op Close to an io.ReadSeeker
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// blobStream reads a blob by decompressing its cluster up to the blob end
+// seeking backward restarts the decompression from the cluster start
+type blobStream struct {
+	z           *ZimReader
+	compression uint8
+	// compressed cluster offsets
+	start, end uint64
+	// blob offset in the uncompressed cluster
+	offset uint64
+	size   int64
+
+	dec io.ReadCloser
+	// pos is the position of the reader, decPos the position of the decoder
+	// in the blob
+	pos, decPos int64
+}
+
+// newBlobStream returns a stream positioned at the start of blob
+func (z *ZimReader) newBlobStream(compression uint8, start, end, blob uint64, extended bool) (*blobStream, error) {
+	dec, bs, be, err := z.seekBlob(compression, start, end, blob, extended, false)
+	if err != nil {
+		return nil, err
+	}
+	return &blobStream{
+		z:           z,
+		compression: compression,
+		start:       start,
+		end:         end,
+		offset:      bs,
+		size:        int64(be - bs),
+		dec:         dec,
+	}, nil
+}
+
+// rewind restarts the decompression at the start of the blob
+func (s *blobStream) rewind() error {
+	if s.dec != nil {
+		s.dec.Close()
+		s.dec = nil
+	}
+	dec, err := s.z.clusterDecoder(s.compression, s.start, s.end)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(io.Discard, dec, int64(s.offset)); err != nil {
+		dec.Close()
+		return s.z.decodeError(err)
+	}
+	s.dec = dec
+	s.decPos = 0
+	return nil
+}
+
+func (s *blobStream) Read(p []byte) (int, error) {
+	if s.pos >= s.size {
+		return 0, io.EOF
+	}
+	if s.dec == nil || s.pos < s.decPos {
+		if err := s.rewind(); err != nil {
+			return 0, err
+		}
+	}
+	if s.pos > s.decPos {
+		if _, err := io.CopyN(io.Discard, s.dec, s.pos-s.decPos); err != nil {
+			return 0, s.z.decodeError(err)
+		}
+		s.decPos = s.pos
+	}
+
+	if rest := s.size - s.pos; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	n, err := s.dec.Read(p)
+	s.pos += int64(n)
+	s.decPos += int64(n)
+	switch {
+	case err == io.EOF && s.pos < s.size:
+		err = corruptf("truncated blob at %d of %d bytes", s.pos, s.size)
+	case err != nil && err != io.EOF:
+		err = s.z.decodeError(err)
+	}
+	return n, err
+}
+
+func (s *blobStream) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	// the decoder moves on the next read
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *blobStream) Close() error {
+	if s.dec == nil {
+		return nil
+	}
+	err := s.dec.Close()
+	s.dec = nil
+	return err
+}
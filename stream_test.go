@@ -0,0 +1,153 @@
+package zim
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArticleOpen(t *testing.T) {
+	var entries []testEntry
+	for i := 0; i < 5; i++ {
+		data := []byte(fmt.Sprintf("%d", i))
+		for len(data) < 50000 {
+			data = append(data, fmt.Sprintf(" blob %d line %d", i, len(data))...)
+		}
+		entries = append(entries, testEntry{ns: 'I', url: fmt.Sprintf("%d.mp4", i), mime: "video/mp4", data: data})
+	}
+
+	redirect := testEntry{ns: 'I', url: "latest.mp4", redirect: "I/0.mp4"}
+
+	for _, compression := range []uint8{1, 4, 5} {
+		for _, mmap := range []bool{false, true} {
+			z := (&testZim{major: 6, compression: compression, blobsPerCluster: 3, entries: append(entries, redirect)}).open(t, mmap)
+			name := fmt.Sprintf("compression %d mmap %v", compression, mmap)
+
+			for _, e := range entries {
+				a, err := z.GetPageNoIndex(e.fullURL())
+				if err != nil {
+					t.Fatal(err)
+				}
+				r, err := a.Open()
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if compression == 1 && mmap {
+					// uncompressed blobs are read from the mapping
+					nc, _ := r.(nopCloser)
+					if _, ok := nc.ReadSeeker.(*bytes.Reader); !ok {
+						t.Errorf("%s: expected a reader over the mapping got %T", name, r)
+					}
+				}
+
+				b, err := io.ReadAll(r)
+				if err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if !bytes.Equal(b, e.data) {
+					t.Errorf("%s: unexpected data for %s", name, e.fullURL())
+				}
+
+				size, err := r.Seek(0, io.SeekEnd)
+				if err != nil || size != int64(len(e.data)) {
+					t.Errorf("%s: expected size %d got %d %v", name, len(e.data), size, err)
+				}
+
+				// seek backward then forward
+				for _, off := range []int64{1000, 10, 40000} {
+					if _, err := r.Seek(off, io.SeekStart); err != nil {
+						t.Fatal(err)
+					}
+					p := make([]byte, 100)
+					if _, err := io.ReadFull(r, p); err != nil {
+						t.Fatalf("%s: %v", name, err)
+					}
+					if !bytes.Equal(p, e.data[off:off+100]) {
+						t.Errorf("%s: unexpected data at %d for %s", name, off, e.fullURL())
+					}
+				}
+
+				if err := r.Close(); err != nil {
+					t.Error(err)
+				}
+			}
+
+			// blobs of cached clusters are read from the cache
+			a, err := z.GetPageNoIndex(entries[4].fullURL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Data(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := a.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(b, entries[4].data) {
+				t.Errorf("%s: unexpected cached data %v", name, err)
+			}
+			r.Close()
+
+			a, err = z.GetPageNoIndex(redirect.fullURL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := a.Open(); err == nil {
+				t.Errorf("%s: a redirect has no data to open", name)
+			}
+		}
+	}
+}
+
+func TestArticleOpenSplit(t *testing.T) {
+	data := bytes.Repeat([]byte("video frame "), 10000)
+	b := (&testZim{major: 6, compression: 1, entries: []testEntry{
+		{ns: 'I', url: "video.mp4", mime: "video/mp4", data: data},
+	}}).bytes(t)
+
+	// the second part starts with the blob, followed by the checksum
+	cut := len(b) - md5.Size - len(data)
+	dir := t.TempDir()
+	for i, part := range [][]byte{b[:cut], b[cut:]} {
+		name := filepath.Join(dir, "video.zima"+string(rune('a'+i)))
+		if err := os.WriteFile(name, part, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, mmap := range []bool{false, true} {
+		z, err := NewReader(filepath.Join(dir, "video.zimaa"), mmap)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := z.GetPageNoIndex("I/video.mp4")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := a.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// only mapped parts are read without copy, the blob being
+		// read in memory otherwise
+		nc, _ := r.(nopCloser)
+		if _, ok := nc.ReadSeeker.(*bytes.Reader); ok != mmap {
+			t.Errorf("mmap %v: unexpected reader %T", mmap, nc.ReadSeeker)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("mmap %v: unexpected data", mmap)
+		}
+		z.Close()
+	}
+}
@@ -0,0 +1,127 @@
+package zim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// return the URL index at position pos in the title index
+func (z *ZimReader) titleIdxAt(pos uint32) (uint32, error) {
+	if pos >= z.ArticleCount {
+		return 0, fmt.Errorf("title index %d %w", pos, ErrNotFound)
+	}
+	offset := z.header.TitlePtrPos + uint64(pos)*4
+	idx, err := readInt32(z.bytesRangeAt(offset, offset+4))
+	if err != nil {
+		return 0, err
+	}
+	if idx >= z.ArticleCount {
+		return 0, corruptf("invalid url index %d at title position %d", idx, pos)
+	}
+	return idx, nil
+}
+
+// searchTitleIdx binary searches the title index and returns the smallest
+// position for which f is true, f must be false then true over the title index
+func (z *ZimReader) searchTitleIdx(f func(a *Article) bool) (uint32, error) {
+	var start uint32
+	stop := z.ArticleCount
+
+	a := new(Article)
+
+	for start < stop {
+		pos := start + (stop-start)/2
+
+		idx, err := z.titleIdxAt(pos)
+		if err != nil {
+			return 0, err
+		}
+		offset, err := z.OffsetAtURLIdx(idx)
+		if err != nil {
+			return 0, err
+		}
+		err = z.FillArticleAt(a, offset)
+		if err != nil {
+			return 0, err
+		}
+
+		if f(a) {
+			stop = pos
+		} else {
+			start = pos + 1
+		}
+	}
+	return start, nil
+}
+
+// titleAtLeast returns a search function matching articles sorted after or
+// equal to title in namespace ns
+func titleAtLeast(ns byte, title string) func(a *Article) bool {
+	return func(a *Article) bool {
+		if a.Namespace != ns {
+			return a.Namespace > ns
+		}
+		return a.sortTitle() >= title
+	}
+}
+
+// GetPageByTitle returns the article with the exact title in namespace ns
+// using a binary search over the title index
+// entries without title are indexed by their url
+func (z *ZimReader) GetPageByTitle(ns byte, title string) (*Article, error) {
+	pos, err := z.searchTitleIdx(titleAtLeast(ns, title))
+	if err != nil {
+		return nil, err
+	}
+	if pos >= z.ArticleCount {
+		return nil, fmt.Errorf("title %q %w", title, ErrNotFound)
+	}
+
+	idx, err := z.titleIdxAt(pos)
+	if err != nil {
+		return nil, err
+	}
+	a, err := z.ArticleAtURLIdx(idx)
+	if err != nil {
+		return nil, err
+	}
+	if a.Namespace != ns || a.sortTitle() != title || a.Kind() == DeletedKind {
+		return nil, fmt.Errorf("title %q %w", title, ErrNotFound)
+	}
+	return a, nil
+}
+
+// TitlesWithPrefix returns up to limit articles in namespace ns whose title
+// starts with prefix, in title order, walking the title index from a binary
+// searched position so it works on any ZIM file without external index
+// a limit <= 0 returns every matching article
+func (z *ZimReader) TitlesWithPrefix(ns byte, prefix string, limit int) ([]*Article, error) {
+	pos, err := z.searchTitleIdx(titleAtLeast(ns, prefix))
+	if err != nil {
+		return nil, err
+	}
+
+	var res []*Article
+	for ; pos < z.ArticleCount; pos++ {
+		if limit > 0 && len(res) >= limit {
+			break
+		}
+
+		idx, err := z.titleIdxAt(pos)
+		if err != nil {
+			return nil, err
+		}
+		a, err := z.ArticleAtURLIdx(idx)
+		if err != nil {
+			return nil, err
+		}
+		if a.Namespace != ns || !strings.HasPrefix(a.sortTitle(), prefix) {
+			break
+		}
+		if a.Kind() == DeletedKind {
+			continue
+		}
+		res = append(res, a)
+	}
+	return res, nil
+}
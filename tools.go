@@ -41,3 +41,29 @@ func readInt16(b []byte, err error) (v uint16, aerr error) {
 
 	return
 }
+
+// read the start and end offsets of a blob, stored on 8 bytes each in extended
+// clusters and 4 bytes otherwise
+func readBlobBounds(b []byte, err error, extended bool) (start, end uint64, aerr error) {
+	if err != nil {
+		aerr = err
+		return
+	}
+	size := blobOffsetSize(extended)
+	start, aerr = readBlobOffset(b[0:size], extended)
+	if aerr != nil {
+		return
+	}
+	end, aerr = readBlobOffset(b[size:2*size], extended)
+	return
+}
+
+// readBlobOffset reads a blob offset stored on 4 bytes, 8 bytes for extended
+// clusters
+func readBlobOffset(b []byte, extended bool) (uint64, error) {
+	if extended {
+		return readInt64(b, nil)
+	}
+	o, err := readInt32(b, nil)
+	return uint64(o), err
+}
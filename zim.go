@@ -2,183 +2,221 @@ package zim
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strings"
 	"sync"
-	"syscall"
-
-	lru "github.com/hashicorp/golang-lru"
 )
 
 const (
 	zimHeader = 72173914
+
+	// strings are read by chunks growing from minStringChunk to maxStringChunk
+	minStringChunk = 256
+	maxStringChunk = 64 << 10
+
+	// maxStringLen is the maximum length of a url, title or mime type
+	maxStringLen = 1 << 20
+
+	// seenClusterCount is the number of recently missed clusters remembered,
+	// a cluster missed twice is fully decompressed and cached
+	seenClusterCount = 256
 )
 
 // ZimReader keep tracks of everything related to ZIM reading
 type ZimReader struct {
-	f             *os.File
-	ArticleCount  uint32
-	clusterCount  uint32
-	urlPtrPos     uint64
-	titlePtrPos   uint64
-	clusterPtrPos uint64
-	mimeListPos   uint64
-	mainPage      uint32
-	layoutPage    uint32
-	mimeTypeList  []string
-	mmap          []byte
+	// r is the source of the ZIM file, a file, a memory mapped file or any
+	// io.ReaderAt provided by the caller
+	r io.ReaderAt
+	// closer is nil when the source is not owned by the reader
+	closer       io.Closer
+	ArticleCount uint32
+	MajorVersion uint16
+	MinorVersion uint16
+	header       Header
+	size         uint64
+	mimeTypeList []string
+	front        frontArticles
+	articlePool  sync.Pool
+	// the recent uncompressed clusters of this file, mainly useful while
+	// indexing and asking for the same blob again and again
+	bcache *clusterCache
+	// the clusters recently partially decompressed
+	seen *clusterCache
+	// the clusters being decompressed
+	decoding flightGroup
+	opts     options
 }
 
 // create a new zim reader
+// path can also be a split zim file, its first .zimaa part or its .zim name
 func NewReader(path string, mmap bool) (*ZimReader, error) {
+	return NewReaderWithOptions(path, WithMmap(mmap))
+}
+
+// NewReaderWithOptions creates a new zim reader configured with opts
+func NewReaderWithOptions(path string, opts ...Option) (*ZimReader, error) {
+	o, err := buildOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// split ZIM files are read as a single contiguous file
+	paths, err := splitPaths(path)
+	if err != nil {
+		return nil, err
+	}
+	if paths != nil {
+		s, err := openSplitFile(paths, o.mmap)
+		if err != nil {
+			return nil, err
+		}
+		return newReader(s, s.size, s, o)
+	}
+
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	z := ZimReader{f: f, mainPage: 0xffffffff, layoutPage: 0xffffffff}
 
 	fi, err := f.Stat()
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
-
 	size := fi.Size()
 
-	if mmap {
-		// we need a multiple of page size bigger than the file
-		pc := size / int64(os.Getpagesize())
-		totalMmap := pc*int64(os.Getpagesize()) + int64(os.Getpagesize())
-		if (size % int64(os.Getpagesize())) == 0 {
-			totalMmap = size
-		}
+	if !o.mmap {
+		return newReader(f, size, f, o)
+	}
 
-		mmap, err := syscall.Mmap(int(f.Fd()), 0, int(totalMmap), syscall.PROT_READ, syscall.MAP_PRIVATE)
-		if err != nil {
-			return nil, fmt.Errorf("can't mmap %w", err)
-		}
-		z.mmap = mmap
+	m, err := newMmapFile(f, size)
+	if err != nil {
+		f.Close()
+		return nil, err
 	}
+	return newReader(m, size, m, o)
+}
 
-	articlePool = sync.Pool{
+// NewReaderFromReaderAt creates a new zim reader reading the size bytes of r,
+// like an embedded file or a file stored in another container
+// the WithMmap option is ignored, closing the ZimReader does not close r
+func NewReaderFromReaderAt(r io.ReaderAt, size int64, opts ...Option) (*ZimReader, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("invalid size %d", size)
+	}
+	o, err := buildOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newReader(r, size, nil, o)
+}
+
+// newReader reads the headers from r, closing closer on failure
+func newReader(r io.ReaderAt, size int64, closer io.Closer, o options) (*ZimReader, error) {
+	z := ZimReader{r: r, closer: closer, size: uint64(size), opts: o}
+
+	z.articlePool = sync.Pool{
 		New: func() interface{} {
 			return new(Article)
 		},
 	}
-	// keep 4 latest uncompressed blobs, around 1M per blob
-	bcache, _ = lru.NewARC(5)
+	z.bcache = newClusterCache(o.cacheCount, o.cacheBytes)
+	z.seen = newClusterCache(seenClusterCount, 0)
 
-	err = z.readFileHeaders()
-	return &z, err
+	if err := z.readFileHeaders(); err != nil {
+		z.Close()
+		return nil, err
+	}
+	return &z, nil
 }
 
 // Return an ordered list of mime types present in the ZIM file
 func (z *ZimReader) MimeTypes() []string {
-	if len(z.mimeTypeList) != 0 {
-		return z.mimeTypeList
-	}
+	return z.mimeTypeList
+}
 
+// read the mime types list, zero terminated strings ending with an empty one
+func (z *ZimReader) readMimeTypes() ([]string, error) {
 	var s []string
-	// assume mime list fit in 2k
-	b, err := z.bytesRangeAt(z.mimeListPos, z.mimeListPos+2048)
-	if err != nil {
-		return s
-	}
-	bbuf := bytes.NewBuffer(b)
-
+	pos := z.header.MimeListPos
 	for {
-		line, err := bbuf.ReadBytes('\x00')
-		if err != nil && err != io.EOF {
-			return s
+		mime, next, err := z.readCString(pos)
+		if err != nil {
+			return nil, fmt.Errorf("can't read mime types list %w", err)
 		}
-		// a line of 1 is a line containing only \x00 and it's the marker for the
-		// end of mime types list
-		if len(line) == 1 {
+		// an empty string is the marker for the end of mime types list
+		if mime == "" {
 			break
 		}
-		s = append(s, strings.TrimRight(string(line), "\x00"))
+		// mime types are indexed by uint16, the highest values being reserved
+		if len(s) >= int(DeletedEntry) {
+			return nil, corruptf("mime types list is too long")
+		}
+		s = append(s, mime)
+		pos = next
 	}
-	z.mimeTypeList = s
-	return s
+	return s, nil
 }
 
-// list all articles, using url index, contained in a zim file
-// note that this is a slow implementation, a real iterator is faster
-// you are not suppose to use this method on big zim files, use indexes
-func (z *ZimReader) ListArticles() <-chan *Article {
-	ch := make(chan *Article, 10)
-
-	go func() {
-		var idx uint32
-		// starting at 1 to avoid "con" entry
-		var start uint32 = 1
-
-		for idx = start; idx < z.ArticleCount; idx++ {
-			art, err := z.ArticleAtURLIdx(idx)
-			if err != nil {
-				continue
-			}
+// readCString reads the zero terminated string starting at offset using
+// incremental reads, it returns the string and the offset following its
+// terminating zero
+func (z *ZimReader) readCString(offset uint64) (string, uint64, error) {
+	var buf []byte
+	chunk := uint64(minStringChunk)
+	for pos := offset; ; {
+		if pos >= z.size {
+			return "", 0, corruptf("unterminated string at 0x%x", offset)
+		}
+		end := pos + chunk
+		if end > z.size {
+			end = z.size
+		}
 
-			if art == nil {
-				// TODO: deal with redirect continue
-			}
-			ch <- art
+		b, err := z.bytesRangeAt(pos, end)
+		if err != nil {
+			return "", 0, err
+		}
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			buf = append(buf, b[:i]...)
+			return string(buf), pos + uint64(i) + 1, nil
 		}
-		close(ch)
-	}()
-	return ch
-}
 
-// list all title pointer, Titles by position contained in a zim file
-// Titles are pointers to URLpos index, useful for indexing cause smaller to store: uint32
-// note that this is a slow implementation, a real iterator is faster
-// you are not suppose to use this method on big zim files prefer ListTitlesPtrIterator to build your index
-func (z *ZimReader) ListTitlesPtr() <-chan uint32 {
-	ch := make(chan uint32, 10)
-
-	go func() {
-		var pos uint64
-		var count uint32
-
-		for pos = z.titlePtrPos; count < z.ArticleCount; pos += 4 {
-			idx, err := readInt32(z.bytesRangeAt(pos, pos+4))
-			if err != nil {
-				continue
-			}
-			ch <- idx
-			count++
+		buf = append(buf, b...)
+		if len(buf) > maxStringLen {
+			return "", 0, corruptf("string at 0x%x is longer than %d bytes", offset, maxStringLen)
+		}
+		pos = end
+		if chunk < maxStringChunk {
+			chunk *= 2
 		}
-		close(ch)
-	}()
-	return ch
+	}
 }
 
 // list all title pointer, Titles by position contained in a zim file
 // Titles are pointers to URLpos index, usefull for indexing cause smaller to store: uint32
-func (z *ZimReader) ListTitlesPtrIterator(cb func(uint32)) {
-	var count uint32
-	for pos := z.titlePtrPos; count < z.ArticleCount; pos += 4 {
-		idx, err := readInt32(z.bytesRangeAt(pos, pos+4))
+// it stops at the first error and returns it
+func (z *ZimReader) ListTitlesPtrIterator(cb func(uint32)) error {
+	for pos := uint32(0); pos < z.ArticleCount; pos++ {
+		idx, err := z.titleIdxAt(pos)
 		if err != nil {
-			continue
+			return err
 		}
 		cb(idx)
-		count++
 	}
+	return nil
 }
 
 // return the article at the exact url not using any index
 func (z *ZimReader) GetPageNoIndex(url string) (*Article, error) {
-	// starting at 1 to avoid "con" entry
 	var start uint32
 	stop := z.ArticleCount
 
 	a := new(Article)
 
-	for {
-		pos := (start + stop) / 2
+	for start < stop {
+		pos := start + (stop-start)/2
 
 		offset, err := z.OffsetAtURLIdx(pos)
 		if err != nil {
@@ -190,57 +228,91 @@ func (z *ZimReader) GetPageNoIndex(url string) (*Article, error) {
 		}
 
 		if a.FullURL() == url {
+			if a.Kind() == DeletedKind {
+				break
+			}
 			return a, nil
 		}
 
 		if a.FullURL() > url {
 			stop = pos
 		} else {
-			start = pos
+			start = pos + 1
 		}
-		if stop-start == 1 {
-			break
+	}
+	return nil, fmt.Errorf("article %s %w", url, ErrNotFound)
+}
+
+// searchURLIdx binary searches the URL index and returns the smallest index
+// for which f is true, f must be false then true over the URL index
+func (z *ZimReader) searchURLIdx(f func(a *Article) bool) (uint32, error) {
+	var start uint32
+	stop := z.ArticleCount
+
+	a := new(Article)
+
+	for start < stop {
+		pos := start + (stop-start)/2
+
+		offset, err := z.OffsetAtURLIdx(pos)
+		if err != nil {
+			return 0, err
+		}
+		err = z.FillArticleAt(a, offset)
+		if err != nil {
+			return 0, err
 		}
 
+		if f(a) {
+			stop = pos
+		} else {
+			start = pos + 1
+		}
 	}
-	return nil, errors.New("article not found")
+	return start, nil
 }
 
 // get the offset pointing to Article at pos in the URL idx
 func (z *ZimReader) OffsetAtURLIdx(idx uint32) (uint64, error) {
-	offset := z.urlPtrPos + uint64(idx)*8
+	if idx >= z.ArticleCount {
+		return 0, fmt.Errorf("url index %d %w", idx, ErrNotFound)
+	}
+	offset := z.header.URLPtrPos + uint64(idx)*8
 	return readInt64(z.bytesRangeAt(offset, offset+8))
 }
 
 // Close & cleanup the zimreader
 func (z *ZimReader) Close() error {
-	return z.f.Close()
+	if z.closer == nil {
+		return nil
+	}
+	return z.closer.Close()
 }
 
 func (z *ZimReader) String() string {
-	fi, err := z.f.Stat()
-	if err != nil {
-		return "corrupted zim"
-	}
-	return fmt.Sprintf("Size: %d, ArticleCount: %d urlPtrPos: 0x%x titlePtrPos: 0x%x mimeListPos: 0x%x clusterPtrPos: 0x%x\nMimeTypes: %v",
-		fi.Size(), z.ArticleCount, z.urlPtrPos, z.titlePtrPos, z.mimeListPos, z.clusterPtrPos, z.MimeTypes())
+	return fmt.Sprintf("Size: %d, Version: %d.%d, UUID: %s, ArticleCount: %d urlPtrPos: 0x%x titlePtrPos: 0x%x mimeListPos: 0x%x clusterPtrPos: 0x%x\nMimeTypes: %v",
+		z.size, z.MajorVersion, z.MinorVersion, z.header.UUID, z.ArticleCount, z.header.URLPtrPos, z.header.TitlePtrPos, z.header.MimeListPos, z.header.ClusterPtrPos, z.MimeTypes())
 }
 
 // getBytesRangeAt returns bytes from start to end
 // it's needed to abstract mmap usages rather than read directly on the mmap slices
 func (z *ZimReader) bytesRangeAt(start, end uint64) ([]byte, error) {
-	if len(z.mmap) > 0 {
-		return z.mmap[start:end], nil
+	if start > end || end > z.size {
+		return nil, corruptf("can't read bytes 0x%x-0x%x past the end of the file", start, end)
+	}
+	if s, ok := z.r.(rangeSlicer); ok {
+		return s.slice(start, end)
 	}
 
 	buf := make([]byte, end-start)
-	n, err := z.f.ReadAt(buf, int64(start))
-	if err != nil {
+	n, err := z.r.ReadAt(buf, int64(start))
+	// a ReaderAt may return io.EOF with a full read at the end of its input
+	if err != nil && !(err == io.EOF && n == len(buf)) {
 		return nil, fmt.Errorf("can't read bytes  %w", err)
 	}
 
 	if n != int(end-start) {
-		return nil, errors.New("can't read enough bytes")
+		return nil, corruptf("can't read bytes 0x%x-0x%x", start, end)
 	}
 
 	return buf, nil
@@ -248,87 +320,70 @@ func (z *ZimReader) bytesRangeAt(start, end uint64) ([]byte, error) {
 
 // populate the ZimReader structs with headers
 func (z *ZimReader) readFileHeaders() error {
-	// checking for file type
-	v, err := readInt32(z.bytesRangeAt(0, 0+4))
-	if err != nil || v != zimHeader {
-		return errors.New("not a ZIM file")
-	}
-
-	// checking for version
-	v, err = readInt32(z.bytesRangeAt(4, 4+4))
-	if err != nil || v != 5 {
-		return errors.New("unsupported version, 5 only")
+	if z.size < headerSize {
+		return ErrNotZim
 	}
-
-	// checking for articles count
-	v, err = readInt32(z.bytesRangeAt(24, 24+4))
+	b, err := z.bytesRangeAt(0, headerSize)
 	if err != nil {
-		return err
+		return fmt.Errorf("can't read header %w", err)
 	}
-	z.ArticleCount = v
 
-	// checking for cluster count
-	v, err = readInt32(z.bytesRangeAt(28, 28+4))
+	h, err := parseHeader(b)
 	if err != nil {
 		return err
 	}
-	z.clusterCount = v
-
-	// checking for urlPtrPos
-	vb, err := readInt64(z.bytesRangeAt(32, 32+8))
-	if err != nil {
+	if err := h.validate(z.size); err != nil {
 		return err
 	}
-	z.urlPtrPos = vb
+	z.header = h
+	z.ArticleCount = h.ArticleCount
+	z.MajorVersion = h.MajorVersion
+	z.MinorVersion = h.MinorVersion
 
-	// checking for titlePtrPos
-	vb, err = readInt64(z.bytesRangeAt(40, 40+8))
-	if err != nil {
-		return err
-	}
-	z.titlePtrPos = vb
+	z.mimeTypeList, err = z.readMimeTypes()
+	return err
+}
 
-	// checking for clusterPtrPos
-	vb, err = readInt64(z.bytesRangeAt(48, 48+8))
-	if err != nil {
-		return err
-	}
-	z.clusterPtrPos = vb
+// Header returns the ZIM file header
+func (z *ZimReader) Header() Header {
+	return z.header
+}
 
-	// checking for mimeListPos
-	vb, err = readInt64(z.bytesRangeAt(56, 56+8))
-	if err != nil {
-		return err
+// return start and end offsets for cluster at index idx
+func (z *ZimReader) clusterOffsetsAtIdx(idx uint32) (start, end uint64, err error) {
+	if idx >= z.header.ClusterCount {
+		err = corruptf("invalid cluster number %d", idx)
+		return
 	}
-	z.mimeListPos = vb
-
-	// checking for mainPage
-	v, err = readInt32(z.bytesRangeAt(64, 64+4))
+	offset := z.header.ClusterPtrPos + (uint64(idx) * 8)
+	start, err = readInt64(z.bytesRangeAt(offset, offset+8))
 	if err != nil {
-		return err
+		return
 	}
-	z.mainPage = v
 
-	// checking for layoutPage
-	v, err = readInt32(z.bytesRangeAt(68, 68+4))
-	if err != nil {
-		return err
+	// the last cluster ends with the checksum or the file
+	var next uint64
+	if idx+1 >= z.header.ClusterCount {
+		next, err = z.lastClusterEnd()
+	} else {
+		offset = z.header.ClusterPtrPos + (uint64(idx+1) * 8)
+		next, err = readInt64(z.bytesRangeAt(offset, offset+8))
 	}
-	z.layoutPage = v
-
-	z.MimeTypes()
-	return nil
-}
-
-// return start and end offsets for cluster at index idx
-func (z *ZimReader) clusterOffsetsAtIdx(idx uint32) (start, end uint64, err error) {
-	offset := z.clusterPtrPos + (uint64(idx) * 8)
-	start, err = readInt64(z.bytesRangeAt(offset, offset+8))
 	if err != nil {
 		return
 	}
-	offset = z.clusterPtrPos + (uint64(idx+1) * 8)
-	end, err = readInt64(z.bytesRangeAt(offset, offset+8))
-	end--
+	if start >= next || next > z.size {
+		err = corruptf("invalid cluster %d offsets 0x%x-0x%x", idx, start, next)
+		return
+	}
+	end = next - 1
 	return
 }
+
+// return the offset where the last cluster ends
+func (z *ZimReader) lastClusterEnd() (uint64, error) {
+	if z.header.HasChecksum() {
+		return z.header.ChecksumPos, nil
+	}
+	return z.size, nil
+}
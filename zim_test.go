@@ -1,7 +1,20 @@
 package zim
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -34,6 +47,76 @@ func TestOpenMmap(t *testing.T) {
 	z.Close()
 }
 
+func TestVersion(t *testing.T) {
+	if Z.MajorVersion != 5 || Z.MinorVersion != 0 {
+		t.Errorf("unexpected version %d.%d", Z.MajorVersion, Z.MinorVersion)
+	}
+}
+
+// copy test.zim with a patched header version
+func writeVersionedZim(t *testing.T, major, minor uint16) string {
+	b, err := os.ReadFile("test.zim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(b[4:], major)
+	binary.LittleEndian.PutUint16(b[6:], minor)
+
+	path := filepath.Join(t.TempDir(), "version.zim")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenVersion6(t *testing.T) {
+	z, err := NewReader(writeVersionedZim(t, 6, 1), false)
+	if err != nil {
+		t.Fatalf("Can't read version 6 %v", err)
+	}
+	defer z.Close()
+
+	if z.MajorVersion != 6 || z.MinorVersion != 1 {
+		t.Errorf("unexpected version %d.%d", z.MajorVersion, z.MinorVersion)
+	}
+}
+
+func TestOpenUnsupportedVersion(t *testing.T) {
+	_, err := NewReader(writeVersionedZim(t, 7, 0), false)
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("version 7 should not be supported got %v", err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	h := Z.Header()
+
+	if h.MagicNumber != zimHeader {
+		t.Errorf("unexpected magic number %d", h.MagicNumber)
+	}
+	if uuid := h.UUID.String(); uuid != "2b875c81-3c8e-0319-351e-ccb1d6b08ef4" {
+		t.Errorf("unexpected UUID %s", uuid)
+	}
+	if h.ArticleCount != Z.ArticleCount || h.ClusterCount != 42 {
+		t.Errorf("unexpected counts %d %d", h.ArticleCount, h.ClusterCount)
+	}
+	if h.MimeListPos != 0x50 || h.URLPtrPos != 0xa8 || h.TitlePtrPos != 0x7e0 || h.ClusterPtrPos != 0x48b0 {
+		t.Errorf("unexpected pointer positions %+v", h)
+	}
+	if !h.HasMainPage() || h.HasLayoutPage() {
+		t.Errorf("unexpected main %d and layout %d pages", h.MainPage, h.LayoutPage)
+	}
+	if !h.HasChecksum() || h.ChecksumPos != 0x650ca {
+		t.Errorf("unexpected checksum position 0x%x", h.ChecksumPos)
+	}
+}
+
+func TestParseHeaderShort(t *testing.T) {
+	if _, err := parseHeader([]byte("ZIM")); !errors.Is(err, ErrNotZim) {
+		t.Errorf("a short header should not parse got %v", err)
+	}
+}
+
 func TestMime(t *testing.T) {
 
 	if len(Z.MimeTypes()) == 0 {
@@ -79,25 +162,123 @@ func TestPageNoIndex(t *testing.T) {
 	}
 }
 
-func TestListArticles(t *testing.T) {
+func TestPageNoIndexBounds(t *testing.T) {
+	// the binary search must reach the first and the last entries
+	for _, idx := range []uint32{0, Z.ArticleCount - 1} {
+		expected, err := Z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := Z.GetPageNoIndex(expected.FullURL())
+		if err != nil {
+			t.Fatalf("can't find url index %d %s: %v", idx, expected.FullURL(), err)
+		}
+		if a.URLPtr != expected.URLPtr {
+			t.Errorf("url index %d: got %s", idx, a.FullURL())
+		}
+	}
+}
+
+func TestEntriesByURL(t *testing.T) {
+	var i uint32
+	var last string
+
+	for a, err := range Z.EntriesByURL(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.FullURL() < last {
+			t.Errorf("%s listed after %s", a.FullURL(), last)
+		}
+		last = a.FullURL()
+		i++
+	}
 
-	if testing.Short() {
-		t.Skip("skipping test in short mode.")
+	if i != Z.ArticleCount {
+		t.Errorf("Can't find the exact ArticleCount urls %d vs %d", i, Z.ArticleCount)
 	}
+}
 
+func TestEntriesByTitle(t *testing.T) {
 	var i uint32
+	var last *Article
 
-	for a := range Z.ListArticles() {
+	for a, err := range Z.EntriesByTitle(context.Background(), 'A') {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Namespace != 'A' {
+			t.Fatalf("unexpected namespace for %s", a.FullURL())
+		}
+		if last != nil && a.sortTitle() < last.sortTitle() {
+			t.Errorf("%q listed after %q", a.sortTitle(), last.sortTitle())
+		}
+		last = a
 		i++
-		t.Log(a.String())
 	}
 
+	start, end, err := Z.NamespaceRange('A')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != end-start {
+		t.Errorf("expected %d titles got %d", end-start, i)
+	}
+}
+
+func TestEntriesByCluster(t *testing.T) {
+	var i int
+	var lastCluster, lastBlob uint32
+
+	for a, err := range Z.EntriesByCluster(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Kind() != ContentKind {
+			t.Fatalf("unexpected %s entry %s", a.Kind(), a.FullURL())
+		}
+		if a.cluster < lastCluster || (a.cluster == lastCluster && a.blob < lastBlob) {
+			t.Errorf("%s listed after cluster %d blob %d", a.FullURL(), lastCluster, lastBlob)
+		}
+		lastCluster, lastBlob = a.cluster, a.blob
+		i++
+	}
 	if i == 0 {
-		t.Errorf("Can't find any urls")
+		t.Error("no content entry listed")
 	}
 
-	if i != Z.ArticleCount-1 {
-		t.Errorf("Can't find the exact ArticleCount urls %d vs %d", i, Z.ArticleCount)
+	// the namespaces are filtered
+	for a, err := range Z.EntriesByCluster(context.Background(), 'M', 'I') {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Namespace != 'M' && a.Namespace != 'I' {
+			t.Errorf("unexpected namespace for %s", a.FullURL())
+		}
+	}
+}
+
+func TestEntriesEarlyExit(t *testing.T) {
+	var i int
+	for _, err := range Z.EntriesByURL(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		i++
+		if i == 3 {
+			break
+		}
+	}
+	if i != 3 {
+		t.Errorf("expected 3 entries got %d", i)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for a, err := range Z.EntriesByTitle(ctx) {
+		if !errors.Is(err, context.Canceled) || a != nil {
+			t.Errorf("expected a canceled error got %v", err)
+		}
 	}
 }
 
@@ -144,7 +325,1300 @@ func BenchmarkArticleBytes(b *testing.B) {
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
 		a.Data()
-		bcache.Purge() // prevent memiozing value
+		Z.bcache.Purge() // prevent memiozing value
+	}
+
+}
+
+// BenchmarkConcurrentClusterReads reads all the blobs of a cluster at the
+// same time, as when a page loads its images, coalesced reads share one
+// decompression when uncoalesced reads decompress the cluster each time
+func BenchmarkConcurrentClusterReads(b *testing.B) {
+	var entries []testEntry
+	for i := 0; i < 32; i++ {
+		data := []byte(strings.Repeat(fmt.Sprintf("image %d of the page, ", i), 2000))
+		entries = append(entries, testEntry{ns: 'I', url: fmt.Sprintf("%02d.png", i), mime: "image/png", data: data})
+	}
+	z := (&testZim{major: 6, compression: 5, blobsPerCluster: len(entries), entries: entries}).open(b, false)
+
+	var err error
+	articles := make([]*Article, len(entries))
+	for i, e := range entries {
+		articles[i], err = z.GetPageNoIndex(e.fullURL())
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	readAll := func(read func(a *Article) error) {
+		var wg sync.WaitGroup
+		for _, a := range articles {
+			wg.Add(1)
+			go func(a *Article) {
+				defer wg.Done()
+				if err := read(a); err != nil {
+					b.Error(err)
+				}
+			}(a)
+		}
+		wg.Wait()
+	}
+
+	b.Run("coalesced", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			// every iteration is a first miss on the cluster
+			z.bcache.Purge()
+			z.seen.Purge()
+			readAll(func(a *Article) error {
+				_, err := a.Data()
+				return err
+			})
+		}
+	})
+
+	b.Run("uncoalesced", func(b *testing.B) {
+		start, end, err := z.clusterOffsetsAtIdx(0)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for i := 0; i < b.N; i++ {
+			readAll(func(a *Article) error {
+				_, err := z.decompressCluster(5, start, end)
+				return err
+			})
+		}
+	})
+}
+
+func TestLastClusterData(t *testing.T) {
+	// M/ entries are stored in the last cluster
+	a, err := Z.GetPageNoIndex("M/Title")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := a.Data()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 {
+		t.Error("can't read data from last cluster")
+	}
+}
+
+func TestClusters(t *testing.T) {
+	entries := []testEntry{
+		{ns: 'A', url: "one.html", title: "One", mime: "text/html", data: []byte("<p>one</p>")},
+		{ns: 'A', url: "two.html", title: "Two", mime: "text/html", data: []byte("<p>two</p>")},
+		{ns: 'A', url: "three.html", title: "Three", mime: "text/html", data: []byte("<p>three</p>")},
+		{ns: 'I', url: "empty.png", mime: "image/png", data: []byte{}},
+		{ns: 'M', url: "Title", mime: "text/plain", data: []byte("Clusters")},
+	}
+
+	tests := []struct {
+		name        string
+		compression uint8
+		extended    bool
+	}{
+		{"uncompressed", 1, false},
+		{"uncompressed extended", 1, true},
+		{"xz", 4, false},
+		{"xz extended", 4, true},
+		{"zstd", 5, false},
+		{"zstd extended", 5, true},
+	}
+
+	for _, tt := range tests {
+		for _, mmap := range []bool{false, true} {
+			tz := &testZim{major: 6, compression: tt.compression, extended: tt.extended, blobsPerCluster: 2, entries: entries}
+			z := tz.open(t, mmap)
+
+			for _, e := range entries {
+				a, err := z.GetPageNoIndex(e.fullURL())
+				if err != nil {
+					t.Fatalf("%s: can't find %s %v", tt.name, e.fullURL(), err)
+				}
+				b, err := a.Data()
+				if err != nil {
+					t.Fatalf("%s: can't read %s %v", tt.name, e.fullURL(), err)
+				}
+				if string(b) != string(e.data) {
+					t.Errorf("%s: got %q for %s expected %q", tt.name, b, e.fullURL(), e.data)
+				}
+			}
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	var done, total uint64
+	err := Z.Verify(context.Background(), func(d, t uint64) {
+		done, total = d, t
+	})
+	if err != nil {
+		t.Fatalf("can't verify test.zim %v", err)
+	}
+	if done == 0 || done != total || total != Z.Header().ChecksumPos {
+		t.Errorf("unexpected progress %d/%d", done, total)
+	}
+}
+
+func TestVerifyCorrupted(t *testing.T) {
+	b, err := os.ReadFile("test.zim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// flip a byte in the middle of the clusters
+	b[len(b)/2] ^= 0xff
+	path := filepath.Join(t.TempDir(), "corrupted.zim")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewReader(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	if err := z.Verify(context.Background(), nil); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("expected a checksum mismatch got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := z.Verify(ctx, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled verification got %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	m, err := Z.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Title != "Wikibooks" || m.Language != "ang" || m.Creator != "Wikibooks" || m.Date != "2014-11-25" {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+	if _, ok := m.Raw["Counter"]; !ok {
+		t.Error("Counter should be in the raw metadata")
+	}
+	// old file using I/favicon.png
+	if len(m.Illustration) == 0 {
+		t.Error("can't read the favicon illustration")
+	}
+}
+
+func TestMetadataNewNamespace(t *testing.T) {
+	png := []byte("\x89PNG illustration")
+	tz := &testZim{major: 6, minor: 1, compression: 5, entries: []testEntry{
+		{ns: 'C', url: "index.html", title: "Index", mime: "text/html", data: []byte("<p>index</p>")},
+		{ns: 'M', url: "Title", mime: "text/plain", data: []byte("New namespace")},
+		{ns: 'M', url: "Language", mime: "text/plain", data: []byte("eng")},
+		{ns: 'M', url: "Name", mime: "text/plain", data: []byte("test_en_all")},
+		{ns: 'M', url: "Flavour", mime: "text/plain", data: []byte("maxi")},
+		{ns: 'M', url: "Tags", mime: "text/plain", data: []byte("_category:test;_pictures:no")},
+		{ns: 'M', url: "Illustration_48x48@1", mime: "image/png", data: png},
+		{ns: 'W', url: "mainPage", redirect: "C/index.html"},
+	}}
+	z := tz.open(t, false)
+
+	m, err := z.Metadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if m.Title != "New namespace" || m.Language != "eng" || m.Name != "test_en_all" ||
+		m.Flavour != "maxi" || m.Tags != "_category:test;_pictures:no" {
+		t.Errorf("unexpected metadata %+v", m)
+	}
+	if string(m.Illustration) != string(png) {
+		t.Errorf("unexpected illustration %q", m.Illustration)
+	}
+	if len(m.Raw) != 6 {
+		t.Errorf("expected 6 raw metadata got %d", len(m.Raw))
+	}
+}
+
+func TestPageByTitle(t *testing.T) {
+	a, err := Z.GetPageByTitle('A', "Dracula:Capitol 1")
+	if err != nil {
+		t.Fatalf("Can't find existing title %v", err)
+	}
+	if a.FullURL() != "A/Dracula:Capitol_1.html" {
+		t.Errorf("unexpected article %s", a.FullURL())
+	}
+
+	// entries without title are sorted by url
+	a, err = Z.GetPageByTitle('M', "Title")
+	if err != nil || a.FullURL() != "M/Title" {
+		t.Errorf("Can't find entry without title %v", err)
+	}
+
+	for _, title := range []string{"Dracula:Capitol", "Zzz", ""} {
+		if _, err := Z.GetPageByTitle('A', title); err == nil {
+			t.Errorf("%q should not be found", title)
+		}
+	}
+}
+
+func TestPageByTitleAll(t *testing.T) {
+	for pos := uint32(0); pos < Z.ArticleCount; pos++ {
+		idx, err := Z.titleIdxAt(pos)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := Z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := Z.GetPageByTitle(a.Namespace, a.sortTitle())
+		if err != nil {
+			t.Fatalf("can't find %s by title %v", a.FullURL(), err)
+		}
+		// titles are not unique, A/index.html is also titled Hēafodsīde
+		if b.Namespace != a.Namespace || b.sortTitle() != a.sortTitle() {
+			t.Errorf("found %s instead of %s", b.FullURL(), a.FullURL())
+		}
+	}
+}
+
+func TestTitlesWithPrefix(t *testing.T) {
+	res, err := Z.TitlesWithPrefix('A', "Dracula", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 5 {
+		t.Fatalf("expected 5 Dracula titles got %d", len(res))
+	}
+	for i, a := range res {
+		if !strings.HasPrefix(a.Title, "Dracula") {
+			t.Errorf("unexpected title %s", a.Title)
+		}
+		if i > 0 && res[i-1].Title > a.Title {
+			t.Errorf("titles are not sorted %s > %s", res[i-1].Title, a.Title)
+		}
+	}
+
+	res, err = Z.TitlesWithPrefix('A', "Dracula", 2)
+	if err != nil || len(res) != 2 {
+		t.Errorf("expected 2 limited titles got %d %v", len(res), err)
+	}
+
+	res, err = Z.TitlesWithPrefix('A', "Nothing like this", 10)
+	if err != nil || len(res) != 0 {
+		t.Errorf("expected no titles got %d %v", len(res), err)
+	}
+
+	// the last namespace
+	res, err = Z.TitlesWithPrefix('M', "", 0)
+	if err != nil || len(res) != 7 {
+		t.Errorf("expected 7 metadata got %d %v", len(res), err)
+	}
+}
+
+func TestNamespaceRange(t *testing.T) {
+	start, end, err := Z.NamespaceRange('M')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != Z.ArticleCount-7 || end != Z.ArticleCount {
+		t.Errorf("unexpected M range [%d, %d)", start, end)
+	}
+
+	start, end, err = Z.NamespaceRange('Z')
+	if err != nil || start != end {
+		t.Errorf("unexpected Z range [%d, %d) %v", start, end, err)
+	}
+}
+
+// newNamespaceEntries are entries using the C namespace, front articles are
+// the html pages
+var newNamespaceEntries = []testEntry{
+	{ns: 'C', url: "index.html", title: "Welcome", mime: "text/html", data: []byte("<p>welcome</p>"), front: true},
+	{ns: 'C', url: "zebra.html", title: "Zebra", mime: "text/html", data: []byte("<p>zebra</p>"), front: true},
+	{ns: 'C', url: "apple.html", title: "Apple", mime: "text/html", data: []byte("<p>apple</p>"), front: true},
+	{ns: 'C', url: "style.css", mime: "text/css", data: []byte("p {}")},
+	{ns: 'C', url: "logo.png", mime: "image/png", data: []byte("\x89PNG")},
+	{ns: 'M', url: "Title", mime: "text/plain", data: []byte("New namespace")},
+	{ns: 'W', url: "mainPage", redirect: "C/index.html"},
+}
+
+func TestNewNamespaceScheme(t *testing.T) {
+	if Z.HasNewNamespaceScheme() || Z.MainNamespace() != ArticleNamespace {
+		t.Error("test.zim uses the old namespace scheme")
+	}
+
+	for _, listing := range []bool{true, false} {
+		tz := &testZim{major: 6, minor: 1, compression: 5, frontListing: listing, entries: newNamespaceEntries}
+		z := tz.open(t, false)
+
+		if !z.HasNewNamespaceScheme() || z.MainNamespace() != ContentNamespace {
+			t.Fatal("expected the new namespace scheme")
+		}
+
+		a, err := z.GetPageByTitle(ContentNamespace, "Zebra")
+		if err != nil || a.FullURL() != "C/zebra.html" {
+			t.Fatalf("can't find C entry by title %v", err)
+		}
+
+		start, end, err := z.NamespaceRange(ContentNamespace)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var front int
+		for idx := start; idx < end; idx++ {
+			ok, err := z.IsFrontArticle(idx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, _ := z.ArticleAtURLIdx(idx)
+			if ok != (a.MimeType() == "text/html") {
+				t.Errorf("listing %v: wrong front article detection for %s", listing, a.FullURL())
+			}
+			if ok {
+				front++
+			}
+		}
+		if front != 3 {
+			t.Errorf("listing %v: expected 3 front articles got %d", listing, front)
+		}
+	}
+}
+
+func TestIsFrontArticleOldScheme(t *testing.T) {
+	for idx := uint32(0); idx < Z.ArticleCount; idx++ {
+		ok, err := Z.IsFrontArticle(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, _ := Z.ArticleAtURLIdx(idx)
+		if ok != (a.Namespace == ArticleNamespace) {
+			t.Errorf("wrong front article detection for %s", a.FullURL())
+		}
+	}
+}
+
+func TestFrontArticles(t *testing.T) {
+	// old scheme, all the A namespace in title order
+	start, end, err := Z.NamespaceRange(ArticleNamespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := Z.FrontArticleCount()
+	if err != nil || count != end-start {
+		t.Fatalf("expected %d front articles got %d %v", end-start, count, err)
+	}
+
+	var titles []string
+	err = Z.ListFrontArticlesIterator(func(idx uint32) {
+		a, err := Z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Namespace != ArticleNamespace {
+			t.Errorf("unexpected front article %s", a.FullURL())
+		}
+		titles = append(titles, a.sortTitle())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint32(len(titles)) != count || !sort.StringsAreSorted(titles) {
+		t.Errorf("front articles are not ordered by title %v", titles)
+	}
+
+	if _, err := Z.FrontArticleIdxAt(count); err == nil {
+		t.Error("expected an out of range error")
+	}
+}
+
+func TestFrontArticlesListing(t *testing.T) {
+	tz := &testZim{major: 6, minor: 1, compression: 5, frontListing: true, entries: newNamespaceEntries}
+	z := tz.open(t, false)
+
+	var urls []string
+	err := z.ListFrontArticlesIterator(func(idx uint32) {
+		a, err := z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		urls = append(urls, a.FullURL())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"C/apple.html", "C/index.html", "C/zebra.html"}
+	if strings.Join(urls, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected front articles %v got %v", expected, urls)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	entries := []testEntry{
+		{ns: 'A', url: "target.html", title: "Target", mime: "text/html", data: []byte("<p>target</p>")},
+		{ns: 'A', url: "one.html", redirect: "A/two.html"},
+		{ns: 'A', url: "two.html", redirect: "A/target.html"},
+		{ns: 'A', url: "loop1.html", redirect: "A/loop2.html"},
+		{ns: 'A', url: "loop2.html", redirect: "A/loop3.html"},
+		{ns: 'A', url: "loop3.html", redirect: "A/loop2.html"},
+		{ns: 'A', url: "self.html", redirect: "A/self.html"},
+	}
+	// a chain longer than the hop limit
+	for i := 0; i <= MaxRedirectHops; i++ {
+		entries = append(entries, testEntry{
+			ns: 'B', url: fmt.Sprintf("long%03d", i), redirect: fmt.Sprintf("B/long%03d", i+1),
+		})
+	}
+	entries = append(entries, testEntry{ns: 'B', url: fmt.Sprintf("long%03d", MaxRedirectHops+1), mime: "text/html", data: []byte("end")})
+
+	tz := &testZim{major: 6, compression: 1, entries: entries}
+	z := tz.open(t, false)
+
+	resolve := func(url string) (*Article, error) {
+		a, err := z.GetPageNoIndex(url)
+		if err != nil {
+			t.Fatalf("can't find %s %v", url, err)
+		}
+		return z.Resolve(a)
+	}
+
+	for _, url := range []string{"A/one.html", "A/two.html", "A/target.html"} {
+		a, err := resolve(url)
+		if err != nil {
+			t.Fatalf("can't resolve %s %v", url, err)
+		}
+		if a.FullURL() != "A/target.html" {
+			t.Errorf("%s resolved to %s", url, a.FullURL())
+		}
+	}
+
+	for _, url := range []string{"A/loop1.html", "A/self.html"} {
+		var loopErr *RedirectLoopError
+		if _, err := resolve(url); !errors.As(err, &loopErr) {
+			t.Errorf("expected a redirect loop for %s got %v", url, err)
+		}
+	}
+
+	if _, err := resolve("B/long000"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("expected too many redirects got %v", err)
+	}
+}
+
+func TestEntryKinds(t *testing.T) {
+	tz := &testZim{major: 5, compression: 5, entries: []testEntry{
+		{ns: 'A', url: "a.html", title: "A", mime: "text/html", data: []byte("a")},
+		{ns: 'A', url: "b.html", title: "B", kind: LinkTargetEntry},
+		{ns: 'A', url: "c.html", title: "C", kind: DeletedEntry},
+		{ns: 'A', url: "d.html", title: "D", mime: "text/html", data: []byte("d")},
+		{ns: 'A', url: "e.html", title: "E", redirect: "A/d.html"},
+		{ns: 'A', url: "f.html", title: "F", redirect: "A/c.html"},
+	}}
+	z := tz.open(t, false)
+
+	kinds := map[string]EntryKind{
+		"A/a.html": ContentKind,
+		"A/b.html": LinkTargetKind,
+		"A/d.html": ContentKind,
+		"A/e.html": RedirectKind,
+		"A/f.html": RedirectKind,
+	}
+	for url, kind := range kinds {
+		a, err := z.GetPageNoIndex(url)
+		if err != nil {
+			t.Fatalf("can't find %s %v", url, err)
+		}
+		if a.Kind() != kind {
+			t.Errorf("%s expected kind %s got %s", url, kind, a.Kind())
+		}
+	}
+
+	a, err := z.GetPageByTitle('A', "B")
+	if err != nil || a.FullURL() != "A/b.html" || a.Title != "B" {
+		t.Fatalf("can't find link target by title %v", err)
+	}
+	if b, err := a.Data(); err != nil || b != nil || a.MimeType() != "" {
+		t.Errorf("link target should not have data %q %v", b, err)
+	}
+
+	// deleted entries are parsed but not found
+	a, err = z.ArticleAtURLIdx(2)
+	if err != nil || a.Kind() != DeletedKind || a.FullURL() != "A/c.html" || a.Title != "C" {
+		t.Errorf("can't parse deleted entry %v %v", a, err)
+	}
+	if _, err := z.GetPageNoIndex("A/c.html"); err == nil {
+		t.Error("deleted entry should not be found")
+	}
+	if _, err := z.GetPageByTitle('A', "C"); err == nil {
+		t.Error("deleted entry should not be found by title")
+	}
+	res, err := z.TitlesWithPrefix('A', "", 0)
+	if err != nil || len(res) != 5 {
+		t.Errorf("expected 5 titles without the deleted entry got %d %v", len(res), err)
+	}
+
+	a, _ = z.GetPageNoIndex("A/f.html")
+	if _, err := z.Resolve(a); err == nil {
+		t.Error("redirect to a deleted entry should not resolve")
+	}
+
+	var count int
+	for _, err := range z.EntriesByURL(context.Background()) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		count++
+	}
+	// the deleted entry is skipped
+	if count != 5 {
+		t.Errorf("expected 5 listed entries got %d", count)
+	}
+}
+
+func TestLongStrings(t *testing.T) {
+	longURL := strings.Repeat("w", 5000) + ".html"
+	longTitle := strings.Repeat("Wiktionary ", 1000)
+	entries := []testEntry{
+		{ns: 'A', url: longURL, title: longTitle, mime: "text/html", data: []byte("long")},
+		{ns: 'A', url: "short.html", title: "Short", mime: "text/html", data: []byte("short")},
+	}
+	// a mime types list bigger than 2k
+	for i := 0; i < 100; i++ {
+		entries = append(entries, testEntry{
+			ns: 'I', url: fmt.Sprintf("%03d.bin", i), mime: fmt.Sprintf("application/x-test-mime-type-%03d", i), data: []byte{byte(i)},
+		})
+	}
+	tz := &testZim{major: 6, compression: 1, entries: entries}
+
+	for _, mmap := range []bool{false, true} {
+		z := tz.open(t, mmap)
+
+		if len(z.MimeTypes()) != 101 {
+			t.Errorf("expected 101 mime types got %d", len(z.MimeTypes()))
+		}
+
+		a, err := z.GetPageNoIndex("A/" + longURL)
+		if err != nil {
+			t.Fatalf("can't find long url %v", err)
+		}
+		if a.Title != longTitle {
+			t.Errorf("title was truncated to %d bytes", len(a.Title))
+		}
+		if a.MimeType() != "text/html" {
+			t.Errorf("unexpected mime type %s", a.MimeType())
+		}
+
+		// the last entry is just before the cluster pointers
+		a, err = z.GetPageNoIndex("I/099.bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, err := a.Data(); err != nil || len(b) != 1 || b[0] != 99 {
+			t.Errorf("unexpected data %v %v", b, err)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	tz := &testZim{major: 6, compression: 1, entries: []testEntry{
+		{ns: 'A', url: "a.html", mime: "text/html", data: []byte("a")},
+	}}
+	b := append(tz.bytes(t), "abc"...)
+	path := filepath.Join(t.TempDir(), "unterminated.zim")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewReader(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	if _, _, err := z.readCString(uint64(len(b) - 3)); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected an error for an unterminated string got %v", err)
+	}
+	if _, _, err := z.readCString(uint64(len(b))); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected an error reading past the end of the file got %v", err)
+	}
+}
+
+func TestSeveralReaders(t *testing.T) {
+	// same urls and cluster layout, different content
+	var readers []*ZimReader
+	for i := 0; i < 4; i++ {
+		var entries []testEntry
+		for j := 0; j < 8; j++ {
+			entries = append(entries, testEntry{
+				ns: 'A', url: fmt.Sprintf("%d.html", j), mime: "text/html", data: []byte(fmt.Sprintf("file %d page %d", i, j)),
+			})
+		}
+		tz := &testZim{major: 6, compression: uint8(4 + i%2), blobsPerCluster: 3, entries: entries}
+		readers = append(readers, tz.open(t, i%2 == 0))
+	}
+
+	var wg sync.WaitGroup
+	for i, z := range readers {
+		for g := 0; g < 4; g++ {
+			wg.Add(1)
+			go func(i int, z *ZimReader) {
+				defer wg.Done()
+				for n := 0; n < 50; n++ {
+					j := n % 8
+					a, err := z.GetPageNoIndex(fmt.Sprintf("A/%d.html", j))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					b, err := a.Data()
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if expected := fmt.Sprintf("file %d page %d", i, j); string(b) != expected {
+						t.Errorf("expected %q got %q", expected, b)
+						return
+					}
+				}
+			}(i, z)
+		}
+	}
+	wg.Wait()
+}
+
+func TestReaderOptions(t *testing.T) {
+	var entries []testEntry
+	for i := 0; i < 6; i++ {
+		entries = append(entries, testEntry{ns: 'A', url: fmt.Sprintf("%d.html", i), mime: "text/html", data: bytes.Repeat([]byte{'a' + byte(i)}, 1000)})
+	}
+	path := (&testZim{major: 6, compression: 5, blobsPerCluster: 2, entries: entries}).write(t)
+
+	readAll := func(z *ZimReader) error {
+		for _, e := range entries {
+			a, err := z.GetPageNoIndex(e.fullURL())
+			if err != nil {
+				return err
+			}
+			b, err := a.Data()
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(b, e.data) {
+				return fmt.Errorf("unexpected data for %s", e.fullURL())
+			}
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		opts       []Option
+		cached     int
+		shouldFail bool
+	}{
+		{"default", nil, 3, false},
+		{"mmap", []Option{WithMmap(true)}, 3, false},
+		{"no cache", []Option{WithClusterCacheCount(0)}, 0, false},
+		{"one cluster", []Option{WithClusterCacheCount(1)}, 1, false},
+		{"byte budget", []Option{WithClusterCacheBytes(4100)}, 2, false},
+		{"decoder concurrency", []Option{WithDecoderConcurrency(1)}, 3, false},
+		{"max cluster size", []Option{WithMaxClusterSize(2100)}, 3, false},
+		{"too small max cluster size", []Option{WithMaxClusterSize(1000)}, 0, true},
+	}
+
+	for _, tt := range tests {
+		z, err := NewReaderWithOptions(path, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		err = readAll(z)
+		if tt.shouldFail != (err != nil) {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+		if z.bcache.Len() != tt.cached {
+			t.Errorf("%s: expected %d cached clusters got %d", tt.name, tt.cached, z.bcache.Len())
+		}
+		z.Close()
+	}
+
+	if _, err := NewReaderWithOptions(path, WithClusterCacheCount(-1)); err == nil {
+		t.Error("a negative cache count should be rejected")
+	}
+}
+
+func TestReaderFromReaderAt(t *testing.T) {
+	b, err := os.ReadFile("test.zim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	z, err := NewReaderFromReaderAt(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	if z.ArticleCount != Z.ArticleCount {
+		t.Errorf("expected %d articles got %d", Z.ArticleCount, z.ArticleCount)
+	}
+
+	for idx := uint32(0); idx < z.ArticleCount; idx++ {
+		a, err := z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := Z.ArticleAtURLIdx(idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.FullURL() != expected.FullURL() {
+			t.Errorf("expected %s got %s", expected.FullURL(), a.FullURL())
+		}
+		if a.Kind() != ContentKind {
+			continue
+		}
+
+		data, err := a.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedData, err := expected.Data()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, expectedData) {
+			t.Errorf("unexpected data for %s", a.FullURL())
+		}
+	}
+
+	if _, err := NewReaderFromReaderAt(bytes.NewReader(b[:40]), 40); err == nil {
+		t.Error("a truncated header should be rejected")
+	}
+	if _, err := NewReaderFromReaderAt(bytes.NewReader(b), -1); err == nil {
+		t.Error("a negative size should be rejected")
+	}
+}
+
+func TestSplitFile(t *testing.T) {
+	b, err := os.ReadFile("test.zim")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// uneven parts so clusters and dirents span several files
+	dir := t.TempDir()
+	sizes := []int{1000, 4096, 777, 10000}
+	for i, rest := 0, b; len(rest) > 0; i++ {
+		n := len(rest)
+		if i < len(sizes) && sizes[i] < n {
+			n = sizes[i]
+		}
+		name := filepath.Join(dir, "test.zima"+string(rune('a'+i)))
+		if err := os.WriteFile(name, rest[:n], 0o600); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+
+	for _, path := range []string{"test.zim", "test.zimaa"} {
+		for _, mmap := range []bool{false, true} {
+			z, err := NewReader(filepath.Join(dir, path), mmap)
+			if err != nil {
+				t.Fatalf("%s mmap %v: %v", path, mmap, err)
+			}
+			if z.size != uint64(len(b)) {
+				t.Errorf("expected size %d got %d", len(b), z.size)
+			}
+			if err := z.Verify(context.Background(), nil); err != nil {
+				t.Errorf("%s mmap %v: %v", path, mmap, err)
+			}
+
+			for idx := uint32(0); idx < z.ArticleCount; idx++ {
+				a, err := z.ArticleAtURLIdx(idx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if a.Kind() != ContentKind {
+					continue
+				}
+				expected, err := Z.ArticleAtURLIdx(idx)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := a.Data()
+				if err != nil {
+					t.Fatal(err)
+				}
+				expectedData, err := expected.Data()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(data, expectedData) {
+					t.Errorf("unexpected data for %s", a.FullURL())
+				}
+			}
+			z.Close()
+		}
 	}
 
+	if _, err := NewReader(filepath.Join(dir, "other.zim"), false); err == nil {
+		t.Error("a missing zim file should fail")
+	}
+}
+
+func TestPartialDecode(t *testing.T) {
+	var entries []testEntry
+	for i := 0; i < 8; i++ {
+		entries = append(entries, testEntry{ns: 'A', url: fmt.Sprintf("%d.html", i), mime: "text/html", data: bytes.Repeat([]byte{'a' + byte(i)}, 1000)})
+	}
+
+	for _, compression := range []uint8{4, 5} {
+		zf := &testZim{major: 6, compression: compression, blobsPerCluster: 4, entries: entries}
+		path := zf.write(t)
+
+		read := func(z *ZimReader, i int) {
+			a, err := z.GetPageNoIndex(entries[i].fullURL())
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := a.Data()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, entries[i].data) {
+				t.Errorf("compression %d: unexpected data for %s", compression, entries[i].fullURL())
+			}
+		}
+
+		z, err := NewReader(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// a blob at the start of the cluster is partially decoded
+		read(z, 0)
+		read(z, 4)
+		if z.bcache.Len() != 0 {
+			t.Errorf("compression %d: expected no cached cluster got %d", compression, z.bcache.Len())
+		}
+		// a cluster missed twice is cached
+		read(z, 1)
+		if z.bcache.Len() != 1 {
+			t.Errorf("compression %d: expected 1 cached cluster got %d", compression, z.bcache.Len())
+		}
+		z.Close()
+
+		// a blob far in the cluster is fully decoded
+		z, err = NewReader(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read(z, 7)
+		if z.bcache.Len() != 1 {
+			t.Errorf("compression %d: expected 1 cached cluster got %d", compression, z.bcache.Len())
+		}
+		z.Close()
+
+		// without cache blobs are always partially decoded
+		z, err = NewReaderWithOptions(path, WithClusterCacheCount(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range entries {
+			read(z, i)
+		}
+		z.Close()
+	}
+}
+
+func TestWalkClusters(t *testing.T) {
+	var entries []testEntry
+	for i := 0; i < 7; i++ {
+		entries = append(entries, testEntry{ns: 'A', url: fmt.Sprintf("%d.html", i), mime: "text/html", data: []byte(fmt.Sprintf("page %d", i))})
+	}
+	entries = append(entries,
+		testEntry{ns: 'A', url: "redirect.html", redirect: "A/0.html"},
+		testEntry{ns: 'I', url: "logo.png", mime: "image/png", data: []byte("png")},
+	)
+
+	for _, compression := range []uint8{1, 4, 5} {
+		z := (&testZim{major: 6, compression: compression, blobsPerCluster: 3, entries: entries}).open(t, false)
+
+		seen := make(map[string]string)
+		var lastCluster uint32
+		err := z.WalkClusters(context.Background(), []byte{'A'}, func(idx uint32, a *Article, data []byte) error {
+			if a.cluster < lastCluster {
+				t.Errorf("compression %d: cluster %d walked after %d", compression, a.cluster, lastCluster)
+			}
+			lastCluster = a.cluster
+			seen[a.FullURL()] = string(data)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(seen) != 7 {
+			t.Errorf("compression %d: expected 7 walked entries got %d", compression, len(seen))
+		}
+		for _, e := range entries[:7] {
+			if seen[e.fullURL()] != string(e.data) {
+				t.Errorf("compression %d: unexpected data for %s %q", compression, e.fullURL(), seen[e.fullURL()])
+			}
+		}
+		if z.bcache.Len() != 0 {
+			t.Errorf("compression %d: walked clusters should not be cached", compression)
+		}
+
+		// errors returned by fn stop the walk
+		stop := errors.New("stop")
+		var calls int
+		err = z.WalkClusters(context.Background(), nil, func(idx uint32, a *Article, data []byte) error {
+			calls++
+			return stop
+		})
+		if err != stop || calls != 1 {
+			t.Errorf("compression %d: expected the walk to stop got %v after %d calls", compression, err, calls)
+		}
+	}
+}
+
+func TestWalkClustersParallel(t *testing.T) {
+	var entries []testEntry
+	for i := 0; i < 40; i++ {
+		entries = append(entries, testEntry{ns: 'A', url: fmt.Sprintf("%02d.html", i), mime: "text/html", data: bytes.Repeat([]byte{'a' + byte(i%26)}, 100+i)})
+	}
+	z := (&testZim{major: 6, compression: 5, blobsPerCluster: 3, entries: entries}).open(t, false)
+
+	type walked struct {
+		idx  uint32
+		data string
+	}
+	walk := func(f func(fn func(idx uint32, a *Article, data []byte) error) error) []walked {
+		var res []walked
+		err := f(func(idx uint32, a *Article, data []byte) error {
+			res = append(res, walked{idx, string(data)})
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	expected := walk(func(fn func(idx uint32, a *Article, data []byte) error) error {
+		return z.WalkClusters(context.Background(), nil, fn)
+	})
+	if len(expected) != len(entries) {
+		t.Fatalf("expected %d entries got %d", len(entries), len(expected))
+	}
+
+	for _, workers := range []int{0, 1, 4, 16} {
+		for _, maxMemory := range []int64{0, 1, 1000} {
+			res := walk(func(fn func(idx uint32, a *Article, data []byte) error) error {
+				return z.WalkClustersParallel(context.Background(), nil, workers, maxMemory, fn)
+			})
+			if fmt.Sprint(res) != fmt.Sprint(expected) {
+				t.Errorf("workers %d memory %d: unexpected walk", workers, maxMemory)
+			}
+		}
+	}
+
+	// errors returned by fn stop the walk
+	stop := errors.New("stop")
+	var calls int
+	err := z.WalkClustersParallel(context.Background(), nil, 4, 0, func(idx uint32, a *Article, data []byte) error {
+		calls++
+		if calls == 5 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || calls != 5 {
+		t.Errorf("expected the walk to stop got %v after %d calls", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = z.WalkClustersParallel(ctx, nil, 4, 1, func(idx uint32, a *Article, data []byte) error {
+		calls++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || calls != 1 {
+		t.Errorf("expected a canceled walk got %v after %d calls", err, calls)
+	}
+}
+
+func BenchmarkWalkClusters(b *testing.B) {
+	var entries []testEntry
+	for i := 0; i < 64; i++ {
+		data := []byte(strings.Repeat(fmt.Sprintf("page %d line, ", i), 20000))
+		entries = append(entries, testEntry{ns: 'A', url: fmt.Sprintf("%02d.html", i), mime: "text/html", data: data})
+	}
+	z := (&testZim{major: 6, compression: 4, blobsPerCluster: 2, entries: entries}).open(b, false)
+	nop := func(idx uint32, a *Article, data []byte) error { return nil }
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := z.WalkClusters(context.Background(), nil, nop); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := z.WalkClustersParallel(context.Background(), nil, 0, 64<<20, nop); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := Z.GetPageNoIndex("A/missing.html"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+	if _, err := Z.GetPageByTitle('A', "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound got %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notzim.zim")
+	if err := os.WriteFile(path, bytes.Repeat([]byte("not a zim "), 20), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewReader(path, false); !errors.Is(err, ErrNotZim) {
+		t.Errorf("expected ErrNotZim got %v", err)
+	}
+
+	tz := &testZim{major: 6, compression: 5, entries: []testEntry{
+		{ns: 'A', url: "a.html", mime: "text/html", data: bytes.Repeat([]byte("a"), 1000)},
+		{ns: 'A', url: "b.html", redirect: "A/a.html"},
+	}}
+	b := tz.bytes(t)
+	path = filepath.Join(t.TempDir(), "compression.zim")
+	clusterPos := binary.LittleEndian.Uint64(b[binary.LittleEndian.Uint64(b[48:56]):])
+	b[clusterPos] = 3
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	z, err := NewReaderWithOptions(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+
+	a, err := z.GetPageNoIndex("A/a.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Data(); !errors.Is(err, ErrUnsupportedCompression) {
+		t.Errorf("expected ErrUnsupportedCompression got %v", err)
+	}
+
+	r, err := z.GetPageNoIndex("A/b.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Open(); !errors.Is(err, ErrNoData) {
+		t.Errorf("expected ErrNoData got %v", err)
+	}
+	if _, err := a.RedirectIndex(); !errors.Is(err, ErrNotRedirect) {
+		t.Errorf("expected ErrNotRedirect got %v", err)
+	}
+
+	z = (&testZim{major: 6, compression: 5, entries: tz.entries}).open(t, false)
+	z.opts.maxClusterSize = 100
+	a, err = z.GetPageNoIndex("A/a.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Data(); !errors.Is(err, ErrClusterTooLarge) {
+		t.Errorf("expected ErrClusterTooLarge got %v", err)
+	}
+}
+
+func TestCorruptOffsets(t *testing.T) {
+	tz := &testZim{major: 6, compression: 1, entries: []testEntry{
+		{ns: 'A', url: "a.html", mime: "text/html", data: []byte("a")},
+		{ns: 'A', url: "b.html", mime: "text/html", data: []byte("b")},
+	}}
+	le := binary.LittleEndian
+
+	tests := []struct {
+		name string
+		// corrupt modifies the file content
+		corrupt func(b []byte)
+		// open is true when the file opens but reading the entry fails
+		open bool
+	}{
+		{"url pointers past the end", func(b []byte) {
+			le.PutUint64(b[32:40], uint64(len(b)))
+		}, false},
+		{"title pointers past the end", func(b []byte) {
+			le.PutUint64(b[40:48], uint64(len(b)-2))
+		}, false},
+		{"cluster pointers past the end", func(b []byte) {
+			le.PutUint64(b[48:56], uint64(len(b)-4))
+		}, false},
+		{"checksum past the end", func(b []byte) {
+			le.PutUint64(b[72:80], uint64(len(b)))
+		}, false},
+		{"dirent past the end", func(b []byte) {
+			le.PutUint64(b[le.Uint64(b[32:40]):], uint64(len(b))+10)
+		}, true},
+		{"cluster past the end", func(b []byte) {
+			le.PutUint64(b[le.Uint64(b[48:56]):], uint64(len(b))+10)
+		}, true},
+		{"blob past the end of the cluster", func(b []byte) {
+			clusterPos := le.Uint64(b[le.Uint64(b[48:56]):])
+			le.PutUint32(b[clusterPos+1+4:], 1000)
+		}, true},
+	}
+
+	for _, tt := range tests {
+		for _, mmap := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s mmap %v", tt.name, mmap), func(t *testing.T) {
+				b := tz.bytes(t)
+				tt.corrupt(b)
+				path := filepath.Join(t.TempDir(), "corrupt.zim")
+				if err := os.WriteFile(path, b, 0o600); err != nil {
+					t.Fatal(err)
+				}
+
+				z, err := NewReader(path, mmap)
+				if !tt.open {
+					if !errors.Is(err, ErrCorrupt) {
+						t.Errorf("expected ErrCorrupt got %v", err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer z.Close()
+
+				a, err := z.ArticleAtURLIdx(0)
+				if err == nil {
+					_, err = a.Data()
+				}
+				if !errors.Is(err, ErrCorrupt) {
+					t.Errorf("expected ErrCorrupt got %v", err)
+				}
+			})
+		}
+	}
+}
+
+var errConnReset = errors.New("connection reset")
+
+// failingReaderAt fails the reads past off once failing is set
+type failingReaderAt struct {
+	r       io.ReaderAt
+	off     int64
+	failing atomic.Bool
+}
+
+func (f *failingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if f.failing.Load() && off+int64(len(p)) > f.off {
+		return 0, errConnReset
+	}
+	return f.r.ReadAt(p, off)
+}
+
+func TestReadErrors(t *testing.T) {
+	var entries []testEntry
+	for i := 0; i < 4; i++ {
+		data := bytes.Repeat([]byte(fmt.Sprintf("blob %d ", i)), 1000)
+		entries = append(entries, testEntry{ns: 'A', url: fmt.Sprintf("%d.html", i), mime: "text/html", data: data})
+	}
+
+	for _, compression := range []uint8{4, 5} {
+		b := (&testZim{major: 6, compression: compression, entries: entries}).bytes(t)
+		clusterPos := binary.LittleEndian.Uint64(b[binary.LittleEndian.Uint64(b[48:56]):])
+		r := &failingReaderAt{r: bytes.NewReader(b), off: int64(clusterPos) + 1}
+		z, err := NewReaderFromReaderAt(r, int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := z.GetPageNoIndex("A/0.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.failing.Store(true)
+
+		// read errors are not corrupt files, on the partial decode of the
+		// first miss, the stream and the full decode
+		check := func(name string, err error) {
+			if !errors.Is(err, errConnReset) || errors.Is(err, ErrCorrupt) {
+				t.Errorf("compression %d %s: expected the read error got %v", compression, name, err)
+			}
+		}
+		_, err = a.Data()
+		check("partial data", err)
+		_, err = a.Open()
+		check("open", err)
+		_, err = a.Data()
+		check("data", err)
+	}
+}
+
+func TestCorruptIndexes(t *testing.T) {
+	tz := &testZim{major: 6, compression: 1, frontListing: true, entries: []testEntry{
+		{ns: 'A', url: "a.html", title: "A", mime: "text/html", data: []byte("a"), front: true},
+		{ns: 'A', url: "c.html", title: "C", redirect: "A/a.html"},
+	}}
+	le := binary.LittleEndian
+
+	// url indexes read from the file are corrupt rather than not found
+	tests := []struct {
+		name    string
+		corrupt func(b []byte)
+		read    func(z *ZimReader) error
+	}{
+		{"title pointer", func(b []byte) {
+			le.PutUint32(b[le.Uint64(b[40:48]):], 9999)
+		}, func(z *ZimReader) error {
+			_, err := z.GetPageByTitle('A', "A")
+			return err
+		}},
+		{"redirect", func(b []byte) {
+			dirent := le.Uint64(b[le.Uint64(b[32:40])+8:])
+			le.PutUint32(b[dirent+8:], 9999)
+		}, func(z *ZimReader) error {
+			a, err := z.GetPageNoIndex("A/c.html")
+			if err != nil {
+				return err
+			}
+			_, err = z.Resolve(a)
+			return err
+		}},
+		{"main page", func(b []byte) {
+			le.PutUint32(b[64:68], 9999)
+		}, func(z *ZimReader) error {
+			_, err := z.MainPage()
+			return err
+		}},
+		{"front articles listing", func(b []byte) {
+			// the listing is the last blob of the single cluster
+			le.PutUint32(b[len(b)-md5.Size-4:], 9999)
+		}, func(z *ZimReader) error {
+			_, err := z.FrontArticleIdxAt(0)
+			return err
+		}},
+	}
+
+	for _, tt := range tests {
+		b := tz.bytes(t)
+		tt.corrupt(b)
+		z, err := NewReaderFromReaderAt(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tt.read(z); !errors.Is(err, ErrCorrupt) || errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: expected ErrCorrupt got %v", tt.name, err)
+		}
+	}
 }
@@ -11,8 +11,8 @@ type ZstdReader struct {
 	*zstd.Decoder
 }
 
-func NewZstdReader(r io.Reader) (*ZstdReader, error) {
-	dec, err := zstd.NewReader(r)
+func NewZstdReader(r io.Reader, opts ...zstd.DOption) (*ZstdReader, error) {
+	dec, err := zstd.NewReader(r, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("can't read from zstd %w", err)
 	}